@@ -0,0 +1,94 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+// Aliased returns a copy of the snapshot with the metrics renamed according to the given map
+// from the original metric names to their aliases, like {"%CPU": "cpu_percent", "RSS": "rss_kb"},
+// so that all the exporters (JSON, Text, Markdown, Graphite, StatsD) produce the same names
+// the downstream schema expects. Metrics not in the map keep their names, and a renamed metric
+// replaces any existing metric with the same name. The original snapshot is not modified.
+// Note that metric accessors like CPU() or RSS() look up the original names, so they do not
+// work on the renamed metrics of the copy.
+func (snap *Snapshot) Aliased(aliases map[string]string) *Snapshot {
+	res := *snap
+
+	res.Root = aliasedTree(snap.Root, aliases)
+	res.Unparented = nil
+
+	for _, node := range snap.Unparented {
+		res.Unparented = append(res.Unparented, aliasedTree(node, aliases))
+	}
+
+	res.Warnings = append([]string(nil), snap.Warnings...)
+	return &res
+}
+
+func aliasedTree(node *ProcNode, aliases map[string]string) *ProcNode {
+	res := &ProcNode{
+		Pid:       node.Pid,
+		ParentPid: node.ParentPid,
+		Stats:     aliasedStats(node.Stats, aliases),
+	}
+
+	if node.Threads != nil {
+		res.Threads = make([]map[string]string, len(node.Threads))
+
+		for i, stat := range node.Threads {
+			res.Threads[i] = aliasedStats(stat, aliases)
+		}
+	}
+
+	if node.Children != nil {
+		res.Children = make([]*ProcNode, len(node.Children))
+
+		for i, child := range node.Children {
+			res.Children[i] = aliasedTree(child, aliases)
+		}
+	}
+
+	return res
+}
+
+func aliasedStats(stat map[string]string, aliases map[string]string) map[string]string {
+	res := make(map[string]string, len(stat))
+
+	for key, val := range stat {
+		if _, ok := aliases[key]; !ok {
+			res[key] = val
+		}
+	}
+
+	for key, val := range stat {
+		if alias, ok := aliases[key]; ok {
+			res[alias] = val
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,68 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestAliased(t *testing.T) {
+	snap, err := Parse(strings.NewReader("PID PPID %CPU RSS CMD\n1 0 0.5 3828 init\n2 0 0 0 kthreadd\n7 1 1.5 512 sh\n"), nil)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res := snap.Aliased(map[string]string{"%CPU": "cpu_percent", "RSS": "rss_kb"})
+
+	if _, ok := snap.Root.Stats["cpu_percent"]; ok {
+		t.Error("Original snapshot is modified")
+		return
+	}
+
+	data, err := json.Marshal(res.Root.Children[0].Stats)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if s := string(data); s != `{"CMD":"sh","cpu_percent":"1.5","rss_kb":"512"}` {
+		t.Errorf("Unexpected stats: %s", s)
+		return
+	}
+
+	if len(res.Unparented) != 1 || res.Unparented[0].Stats["rss_kb"] != "0" {
+		t.Errorf("Unexpected unparented processes: %v", res.Unparented)
+		return
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Finding is a process flagged by one of the audits, along with the explanation.
+type Finding struct {
+	Node   *ProcNode
+	Reason string
+}
+
+// Zombies returns the list of zombie processes from the subtree. The audit requires either "stat"
+// or "s" (a.k.a. "state") column.
+func (root *ProcNode) Zombies() (res []Finding) {
+	root.ForEach(func(node *ProcNode) {
+		if s := procState(node); len(s) > 0 && s[0] == 'Z' {
+			res = append(res, Finding{node, fmt.Sprintf("Zombie process not reaped by its parent %d", node.ParentPid)})
+		}
+	})
+
+	return
+}
+
+// Orphans returns the list of processes re-parented to pid 1 after their original parent exited.
+// A child of pid 1 is considered an orphan if it is not a session leader, because services
+// and daemons normally start their own sessions. The audit requires "sid" (a.k.a. "sess",
+// "session") column, and without it the result is always empty.
+func (root *ProcNode) Orphans() (res []Finding) {
+	pids := make(map[int]bool, 100)
+
+	root.ForEach(func(node *ProcNode) {
+		pids[node.Pid] = true
+	})
+
+	root.ForEach(func(node *ProcNode) {
+		if node.ParentPid != 1 {
+			return
+		}
+
+		sid, err := strconv.Atoi(firstValue(node, "SID", "SESS"))
+
+		if err != nil || sid == node.Pid || sid <= 1 {
+			return
+		}
+
+		if pids[sid] {
+			res = append(res, Finding{node, fmt.Sprintf("Re-parented to pid 1, session leader %d is still running", sid)})
+		} else {
+			res = append(res, Finding{node, fmt.Sprintf("Re-parented to pid 1, session leader %d is gone", sid)})
+		}
+	})
+
+	return
+}
+
+// SuspiciousStates returns the list of processes in states that usually indicate a problem:
+// uninterruptible sleep, stopped, traced, zombie, or dead. The audit requires either "stat"
+// or "s" (a.k.a. "state") column.
+func (root *ProcNode) SuspiciousStates() (res []Finding) {
+	root.ForEach(func(node *ProcNode) {
+		if s := procState(node); len(s) > 0 {
+			if reason, ok := suspiciousStates[s[0]]; ok {
+				res = append(res, Finding{node, reason})
+			}
+		}
+	})
+
+	return
+}
+
+var suspiciousStates = map[byte]string{
+	'D': "Uninterruptible sleep (usually waiting for I/O)",
+	'T': "Stopped by job control signal",
+	't': "Stopped by debugger during tracing",
+	'X': "Dead",
+	'Z': "Zombie process",
+}
+
+// process state from either "stat" or "s" column
+func procState(node *ProcNode) string {
+	if s, ok := node.Stats["STAT"]; ok {
+		return s
+	}
+
+	return node.Stats["S"]
+}
@@ -0,0 +1,98 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestAudits(t *testing.T) {
+	root, err := pstree(cat("audit"))
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	type test struct {
+		name     string
+		findings []Finding
+		exp      []int
+	}
+
+	tests := []test{
+		{"Zombies", root.Zombies(), []int{2245}},
+		{"Orphans", root.Orphans(), []int{512, 513}},
+		{"SuspiciousStates", root.SuspiciousStates(), []int{513, 2245, 2250}},
+	}
+
+	for _, tst := range tests {
+		pids := make([]int, 0, len(tst.findings))
+
+		for _, f := range tst.findings {
+			if len(f.Reason) == 0 {
+				t.Errorf("%s: empty reason for pid %d", tst.name, f.Node.Pid)
+				return
+			}
+
+			pids = append(pids, f.Node.Pid)
+		}
+
+		sort.Ints(pids)
+
+		if len(pids) != len(tst.exp) {
+			t.Errorf("%s: unexpected pids: %v instead of %v", tst.name, pids, tst.exp)
+			return
+		}
+
+		for i, pid := range tst.exp {
+			if pids[i] != pid {
+				t.Errorf("%s: unexpected pids: %v instead of %v", tst.name, pids, tst.exp)
+				return
+			}
+		}
+	}
+}
+
+func TestOrphansSess(t *testing.T) {
+	// procps prints "SESS" header for both "sess" and "session" columns
+	snap, err := Parse(strings.NewReader("PID PPID SESS CMD\n1 0 1 /sbin/init\n10 1 10 /bin/daemon\n20 1 15 /bin/orphan\n"), nil)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := snap.Root.Orphans(); len(res) != 1 || res[0].Node.Pid != 20 {
+		t.Errorf("Unexpected orphans: %+v", res)
+		return
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Cache keeps the most recent snapshot for each combination of target and options, and returns it
+// instead of collecting a new one while the snapshot is younger than the cache TTL. It is intended
+// for web handlers and similar code that can be invoked in bursts, but should not overload
+// the target host. Concurrent requests for the same target and options result in only one
+// collection. Snapshots returned from the cache are shared between the callers and must not
+// be modified. A Cache is safe for concurrent use.
+type Cache struct {
+	ttl     time.Duration
+	lock    sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+// entry lock serialises collections for the same key, while the snapshot and its time are
+// guarded by the cache lock
+type cacheEntry struct {
+	lock sync.Mutex
+	snap *Snapshot
+	time time.Time
+}
+
+// NewCache creates a new Cache with the given TTL.
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{
+		ttl:     ttl,
+		entries: make(map[string]*cacheEntry),
+	}
+}
+
+// Collect is the same as the function Collect(), but it returns the cached snapshot if the snapshot
+// is fresher than the cache TTL. Failed collections are not cached.
+func (c *Cache) Collect(ssh []string, opts *Options, columns ...string) (*Snapshot, error) {
+	e := c.entry(cacheKey(ssh, opts, columns))
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if snap := c.fresh(e); snap != nil {
+		return snap, nil
+	}
+
+	snap, err := Collect(ssh, opts, columns...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	e.snap, e.time = snap, time.Now()
+	c.lock.Unlock()
+
+	return snap, nil
+}
+
+// Clear removes all snapshots from the cache.
+func (c *Cache) Clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries = make(map[string]*cacheEntry)
+}
+
+// finds or creates the entry for the given key, also dropping expired entries
+func (c *Cache) entry(key string) *cacheEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e := c.entries[key]
+
+	if e == nil {
+		for k, x := range c.entries {
+			if x.snap != nil && time.Since(x.time) >= c.ttl {
+				delete(c.entries, k)
+			}
+		}
+
+		e = new(cacheEntry)
+		c.entries[key] = e
+	}
+
+	return e
+}
+
+// returns the snapshot from the entry if the snapshot is not expired
+func (c *Cache) fresh(e *cacheEntry) *Snapshot {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e.snap != nil && time.Since(e.time) < c.ttl {
+		return e.snap
+	}
+
+	return nil
+}
+
+// cache key: the command to run, plus the options affecting the snapshot after the command is run;
+// the user enrichers are keyed by their names, because calling Enricher.Command() here would reset
+// the state of the enricher while it may be in use by a collection
+func cacheKey(ssh []string, opts *Options, columns []string) string {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	cmdOpts := *opts
+	cmdOpts.Enrichers = nil
+
+	var enrichers []string
+
+	for _, e := range opts.Enrichers {
+		enrichers = append(enrichers, fmt.Sprintf("%s:%t", e.Name(), isMandatory(e)))
+	}
+
+	key := fmt.Sprintf("%q %q %d %d %d %t %t %d %d", Command(ssh, &cmdOpts, columns...), enrichers,
+		opts.Limits.MaxEnrichmentTime, opts.Duplicates, opts.Missing, opts.Canonical, opts.ChildCounts,
+		opts.Limits.MaxProcesses, opts.Limits.MaxBytes)
+
+	for _, k := range sortedKeys(opts.Defaults) {
+		key += fmt.Sprintf(" %q=%q", k, opts.Defaults[k])
+	}
+
+	return key
+}
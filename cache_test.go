@@ -0,0 +1,212 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	cache := NewCache(time.Hour)
+
+	s1, err := cache.Collect(nil, nil, "rss")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	s2, err := cache.Collect(nil, &Options{}, "rss")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if s1 != s2 {
+		t.Error("Snapshot is not cached")
+		return
+	}
+
+	s3, err := cache.Collect(nil, &Options{CPUCount: true}, "rss")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if s3 == s1 {
+		t.Error("Snapshot with different options is returned from the cache")
+		return
+	}
+
+	cache.Clear()
+
+	if s2, err = cache.Collect(nil, nil, "rss"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if s1 == s2 {
+		t.Error("Snapshot is not removed from the cache")
+		return
+	}
+
+	// zero TTL
+	cache = NewCache(0)
+
+	if s1, err = cache.Collect(nil, nil, "rss"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if s2, err = cache.Collect(nil, nil, "rss"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if s1 == s2 {
+		t.Error("Expired snapshot is returned from the cache")
+		return
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	columns := []string{"rss", "vsz", "%cpu", "nlwp", "cmd", "user"}
+	key := cacheKey(nil, nil, columns)
+
+	for i := 0; i < 50; i++ {
+		if s := cacheKey(nil, nil, columns); s != key {
+			t.Errorf("Unstable cache key:\n%s\n%s", key, s)
+			return
+		}
+	}
+
+	cache := NewCache(time.Hour)
+	s1, err := cache.Collect(nil, nil, columns...)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < 10; i++ {
+		s2, err := cache.Collect(nil, nil, columns...)
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if s1 != s2 {
+			t.Error("Snapshot is not cached")
+			return
+		}
+	}
+}
+
+func TestCacheKeyChildCounts(t *testing.T) {
+	// child counts are computed after the command is run, so they must be a part of the key
+	if cacheKey(nil, &Options{ChildCounts: true}, nil) == cacheKey(nil, nil, nil) {
+		t.Error("ChildCounts option is not in the cache key")
+		return
+	}
+
+	cache := NewCache(time.Hour)
+	s1, err := cache.Collect(nil, nil)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	s2, err := cache.Collect(nil, &Options{ChildCounts: true})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if s1 == s2 {
+		t.Error("Snapshot without child counts is returned from the cache")
+		return
+	}
+
+	var found bool
+
+	s2.forEach(func(node *ProcNode) {
+		_, ok := node.Stats["CHILDREN"]
+		found = found || ok
+	})
+
+	if !found {
+		t.Error("Child counts are missing")
+		return
+	}
+}
+
+func TestCacheSharedEnricher(t *testing.T) {
+	// with zero TTL every call collects, and the collections are serialised by the cache,
+	// so the shared enricher must never be touched by more than one of them at a time
+	cache := NewCache(0)
+	opts := &Options{Enrichers: []Enricher{ProcStatsEnricher("zz", "echo 1 ZZ 42")}}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 5; j++ {
+				if _, err := cache.Collect(nil, opts, "rss"); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+		return
+	}
+
+	if cacheKey(nil, opts, nil) == cacheKey(nil, nil, nil) {
+		t.Error("Enrichers are not in the cache key")
+		return
+	}
+}
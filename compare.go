@@ -0,0 +1,149 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"math"
+	"sort"
+)
+
+// Divergence describes a difference of one host from the others, as reported by Compare().
+// If the Metric is empty, the process with the given identity is absent on the host, otherwise
+// the value of the metric on the host is an outlier.
+type Divergence struct {
+	Identity string
+	Host     string
+	Metric   string
+	Value    float64 // value of the metric on the host
+	Median   float64 // median value of the metric across the hosts
+}
+
+// Compare aligns the process trees from several hosts (given as a map from host name to snapshot)
+// by process identity, as computed by the given function (nil means Fingerprint), and reports
+// the divergences: processes present on some hosts, but absent on others, and the values
+// of the given metrics that deviate from their median across the hosts by more than
+// the given relative tolerance (for example, 0.5 means 50%). Metric values of all the processes
+// with the same identity on a host are summed up, and hosts without the process or the metric
+// are not taken into account. With only two hosts the median is the average of the two values,
+// so an outlier is always reported for both hosts. The result is sorted by identity, host,
+// and metric.
+func Compare(snaps map[string]*Snapshot, id IdentityFunc, tolerance float64, metrics ...string) (res []Divergence) {
+	hosts := make(map[string]map[string][]*ProcNode, len(snaps))
+	all := make(map[string]bool)
+
+	for host, snap := range snaps {
+		hosts[host] = snap.Identities(id)
+
+		for key := range hosts[host] {
+			all[key] = true
+		}
+	}
+
+	for key := range all {
+		// absent processes
+		for host, ids := range hosts {
+			if len(ids[key]) == 0 {
+				res = append(res, Divergence{Identity: key, Host: host})
+			}
+		}
+
+		// outliers
+		for _, metric := range metrics {
+			values := make(map[string]float64, len(hosts))
+
+			for host, ids := range hosts {
+				if val, ok := sumMetric(ids[key], metric); ok {
+					values[host] = val
+				}
+			}
+
+			if len(values) < 2 {
+				continue
+			}
+
+			median := medianOf(values)
+
+			for host, val := range values {
+				if math.Abs(val-median) > tolerance*math.Abs(median) {
+					res = append(res, Divergence{
+						Identity: key,
+						Host:     host,
+						Metric:   metric,
+						Value:    val,
+						Median:   median,
+					})
+				}
+			}
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		a, b := &res[i], &res[j]
+
+		if a.Identity != b.Identity {
+			return a.Identity < b.Identity
+		}
+
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+
+		return a.Metric < b.Metric
+	})
+
+	return
+}
+
+// sum of the metric over the given processes
+func sumMetric(nodes []*ProcNode, metric string) (sum float64, found bool) {
+	for _, node := range nodes {
+		if val, ok := node.Float(metric); ok {
+			sum += val
+			found = true
+		}
+	}
+
+	return
+}
+
+func medianOf(values map[string]float64) float64 {
+	list := make([]float64, 0, len(values))
+
+	for _, val := range values {
+		list = append(list, val)
+	}
+
+	sort.Float64s(list)
+
+	if n := len(list); n%2 == 0 {
+		return (list[n/2-1] + list[n/2]) / 2
+	}
+
+	return list[len(list)/2]
+}
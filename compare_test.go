@@ -0,0 +1,73 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	srcs := map[string]string{
+		"a": "PID PPID RSS CMD\n1 0 1000 /sbin/init\n10 1 500 /usr/sbin/sshd -D\n20 1 100 /usr/sbin/cron -f\n",
+		"b": "PID PPID RSS CMD\n1 0 1010 /sbin/init\n11 1 510 /usr/sbin/sshd -D\n21 1 100 /usr/sbin/cron -f\n",
+		"c": "PID PPID RSS CMD\n1 0 990 /sbin/init\n12 1 5000 /usr/sbin/sshd -D\n",
+	}
+
+	snaps := make(map[string]*Snapshot, len(srcs))
+
+	for host, src := range srcs {
+		snap, err := Parse(strings.NewReader(src), nil)
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		snaps[host] = snap
+	}
+
+	res := Compare(snaps, IdentityOf("CMD"), 0.5, "RSS")
+
+	if len(res) != 2 {
+		t.Errorf("Unexpected number of divergences: %d instead of 2", len(res))
+		return
+	}
+
+	if s := fmt.Sprint(res[0]); s != "{/usr/sbin/cron -f c  0 0}" {
+		t.Errorf("Unexpected divergence: %s", s)
+		return
+	}
+
+	if s := fmt.Sprint(res[1]); s != "{/usr/sbin/sshd -D c RSS 5000 510}" {
+		t.Errorf("Unexpected divergence: %s", s)
+		return
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"sort"
+	"time"
+)
+
+// CrashLoop describes a service that has been restarted repeatedly.
+type CrashLoop struct {
+	// Identity of the service, as computed by the IdentityFunc
+	Identity string
+
+	// Number of restarts within the time window
+	Restarts int
+
+	// Time of each restart within the window, in ascending order
+	Times []time.Time
+
+	// Intervals between consecutive restarts
+	Intervals []time.Duration
+
+	// The most recent process of the service
+	Last *ProcNode
+}
+
+// CrashLoops finds services that have been restarted at least 'minRestarts' times within the given time
+// window before the latest snapshot. Snapshots must be of the same host, and in the order of collection.
+// A service is identified by the given IdentityFunc (nil means Fingerprint), and a restart is
+// a process with a new pid for an identity, replacing a process of the same identity that is gone since
+// the previous snapshot where the identity was seen, so new processes of a service that keeps all its
+// earlier ones running (like a pool of workers growing) are not restarts. The restart time is
+// the process start time (see Snapshot.StartTime()) if available, otherwise the snapshot time.
+// Restarts that happened between the snapshots without being captured cannot be detected, so the
+// collection interval should be shorter than the expected lifetime of a crashing process.
+// The result is sorted by identity.
+func CrashLoops(snaps []*Snapshot, id IdentityFunc, window time.Duration, minRestarts int) []CrashLoop {
+	if len(snaps) < 2 {
+		return nil
+	}
+
+	type service struct {
+		pids  map[int]bool
+		times []time.Time
+		last  *ProcNode
+	}
+
+	services := make(map[string]*service)
+
+	for _, snap := range snaps {
+		for key, nodes := range snap.Identities(id) {
+			s := services[key]
+
+			if s == nil {
+				s = &service{pids: make(map[int]bool)}
+				services[key] = s
+			}
+
+			current := make(map[int]bool, len(nodes))
+
+			for _, node := range nodes {
+				current[node.Pid] = true
+			}
+
+			// number of the previously seen processes that are gone
+			var gone int
+
+			for pid := range s.pids {
+				if !current[pid] {
+					gone++
+				}
+			}
+
+			// each new process replacing a gone one is a restart
+			for _, node := range nodes {
+				if gone == 0 {
+					break
+				}
+
+				if s.pids[node.Pid] {
+					continue
+				}
+
+				ts, ok := snap.StartTime(node)
+
+				if !ok {
+					ts = snap.Time
+				}
+
+				s.times = append(s.times, ts)
+				gone--
+			}
+
+			s.pids = current
+
+			s.last = nodes[len(nodes)-1]
+		}
+	}
+
+	since := snaps[len(snaps)-1].Time.Add(-window)
+
+	var res []CrashLoop
+
+	for key, s := range services {
+		sort.Slice(s.times, func(i, j int) bool { return s.times[i].Before(s.times[j]) })
+
+		i := sort.Search(len(s.times), func(i int) bool { return !s.times[i].Before(since) })
+
+		if times := s.times[i:]; len(times) > 0 && len(times) >= minRestarts {
+			loop := CrashLoop{
+				Identity: key,
+				Restarts: len(times),
+				Times:    times,
+				Last:     s.last,
+			}
+
+			for j := 1; j < len(times); j++ {
+				loop.Intervals = append(loop.Intervals, times[j].Sub(times[j-1]))
+			}
+
+			res = append(res, loop)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Identity < res[j].Identity })
+	return res
+}
@@ -0,0 +1,115 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCrashLoops(t *testing.T) {
+	srcs := []string{
+		"PID PPID ELAPSED CMD\n1 0 1000 /sbin/init\n20 1 900 /bin/stable\n10 1 100 /bin/svc -x\n",
+		"PID PPID ELAPSED CMD\n1 0 1010 /sbin/init\n20 1 910 /bin/stable\n11 1 5 /bin/svc -x\n",
+		"PID PPID ELAPSED CMD\n1 0 1020 /sbin/init\n20 1 920 /bin/stable\n12 1 3 /bin/svc -x\n13 1 2 /bin/svc -y\n",
+	}
+
+	var snaps []*Snapshot
+
+	for _, src := range srcs {
+		snap, err := Parse(strings.NewReader(src), nil)
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		snaps = append(snaps, snap)
+	}
+
+	loops := CrashLoops(snaps, nil, time.Minute, 2)
+
+	if len(loops) != 1 {
+		t.Errorf("Unexpected number of crash loops: %d instead of 1", len(loops))
+		return
+	}
+
+	loop := loops[0]
+
+	if !strings.HasPrefix(loop.Identity, ":/bin/svc:") || loop.Restarts != 2 || loop.Last.Pid != 12 {
+		t.Errorf("Unexpected crash loop: %q, %d restarts, last pid %d", loop.Identity, loop.Restarts, loop.Last.Pid)
+		return
+	}
+
+	if len(loop.Intervals) != 1 || loop.Intervals[0] < time.Second || loop.Intervals[0] > 3*time.Second {
+		t.Errorf("Unexpected intervals: %v", loop.Intervals)
+		return
+	}
+
+	// restarts outside the window
+	if loops = CrashLoops(snaps, nil, time.Second, 1); len(loops) != 0 {
+		t.Errorf("Unexpected number of crash loops: %d instead of 0", len(loops))
+		return
+	}
+}
+
+func TestCrashLoopsWorkers(t *testing.T) {
+	srcs := []string{
+		"PID PPID ELAPSED CMD\n1 0 1000 /sbin/init\n30 1 900 /bin/worker\n31 1 900 /bin/worker\n",
+		"PID PPID ELAPSED CMD\n1 0 1010 /sbin/init\n30 1 910 /bin/worker\n31 1 910 /bin/worker\n32 1 5 /bin/worker\n",
+		"PID PPID ELAPSED CMD\n1 0 1020 /sbin/init\n30 1 920 /bin/worker\n32 1 15 /bin/worker\n33 1 2 /bin/worker\n",
+	}
+
+	var snaps []*Snapshot
+
+	for _, src := range srcs {
+		snap, err := Parse(strings.NewReader(src), nil)
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		snaps = append(snaps, snap)
+	}
+
+	// the pool growing from 2 to 3 workers is not a restart, but the replacement of worker 31 is
+	loops := CrashLoops(snaps, nil, time.Minute, 1)
+
+	if len(loops) != 1 || loops[0].Restarts != 1 {
+		t.Errorf("Unexpected crash loops: %+v", loops)
+		return
+	}
+
+	if loops = CrashLoops(snaps[:2], nil, time.Minute, 1); len(loops) != 0 {
+		t.Errorf("Unexpected crash loops: %+v", loops)
+		return
+	}
+}
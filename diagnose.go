@@ -0,0 +1,188 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maxim2266/strit"
+)
+
+// Diagnosis is the report produced by Diagnose().
+type Diagnosis struct {
+	Probe  *ProbeResult  // result of the probe, or nil if the probe has failed
+	Checks []Check       // all the checks performed, in order
+	Skew   time.Duration // clock skew of the target (remote time minus local time), if the clock check has passed
+}
+
+// Check is the result of one diagnostic check.
+type Check struct {
+	// Check name: the probe stage ("command", "connect", "auth", or "ps"), "column <name>",
+	// "proc" (process information is readable), "proc details" (memory details are readable,
+	// see MemoryDetailsEnricher()), "sudo" (sudo without password is available), or "clock".
+	Name string
+	OK   bool
+	Msg  string // details or the reason of the failure
+	Info bool   // informational check ("proc details", "sudo"), its failure does not affect Diagnosis.OK()
+}
+
+// OK returns 'true' if all the checks have passed, except the informational ones.
+func (d *Diagnosis) OK() bool {
+	for _, c := range d.Checks {
+		if !c.OK && !c.Info {
+			return false
+		}
+	}
+
+	return true
+}
+
+// maximum clock skew considered normal
+const maxClockSkew = time.Second
+
+// Diagnose validates the target end-to-end: it runs Probe() to check reachability, authentication,
+// and 'ps' availability, and then checks on the target that each of the given 'ps' columns (or
+// the default columns if none given) is supported, /proc is readable, sudo can be used
+// without password, and the clock skew is within one second. Any failure is reported as a failed
+// check with the reason, so the function never fails itself. The sudo check is informational,
+// as sudo is not required for the collection.
+func Diagnose(ssh []string, columns ...string) *Diagnosis {
+	res := new(Diagnosis)
+	probe, err := Probe(ssh)
+
+	if err != nil {
+		if e, ok := err.(*ProbeError); ok {
+			res.Checks = append(res.Checks, Check{Name: e.Stage, Msg: e.Msg})
+		} else {
+			res.Checks = append(res.Checks, Check{Name: "command", Msg: err.Error()})
+		}
+
+		return res
+	}
+
+	res.Probe = probe
+	res.Checks = append(res.Checks, Check{
+		Name: "ps",
+		OK:   true,
+		Msg:  strings.TrimSpace(probe.Flavor + " " + probe.Version),
+	})
+
+	if len(columns) == 0 {
+		columns = psDefaultColumns
+	}
+
+	// run the checks
+	cmd := shellCommand(ssh, diagnosticScript(columns))
+	results := make(map[string]string, len(columns)+4)
+	start := time.Now()
+
+	err = strit.FromCommand(exec.Command(cmd[0], cmd[1:]...))(func(line []byte) error {
+		if kv := strings.SplitN(strings.TrimSpace(string(line)), " ", 2); len(kv) == 2 {
+			results[kv[0]] = kv[1]
+		}
+
+		return nil
+	})
+
+	end := time.Now()
+
+	if err != nil {
+		res.Checks = append(res.Checks, Check{Name: "command", Msg: mapCmdError(err).Error()})
+		return res
+	}
+
+	for i, col := range columns {
+		c := Check{Name: "column " + col, OK: results["column"+strconv.Itoa(i)] == "ok"}
+
+		if !c.OK {
+			c.Msg = "Column is not supported by 'ps'"
+		}
+
+		res.Checks = append(res.Checks, c)
+	}
+
+	details := flagCheck("proc details", results["details"], "Memory details in /proc are not readable, try sudo")
+	details.Info = true
+
+	sudo := flagCheck("sudo", results["sudo"], "Sudo without password is not available")
+	sudo.Info = true
+
+	res.Checks = append(res.Checks,
+		flagCheck("proc", results["proc"], "Process information in /proc is not readable"),
+		details,
+		sudo,
+	)
+
+	// clock skew, relative to the middle of the command run
+	clock := Check{Name: "clock"}
+
+	if remote, err := parseRemoteTime(results["time"]); err != nil {
+		clock.Msg = err.Error()
+	} else {
+		res.Skew = remote.Sub(start.Add(end.Sub(start) / 2))
+
+		if clock.OK = (res.Skew < maxClockSkew && res.Skew > -maxClockSkew); !clock.OK {
+			clock.Msg = "Clock skew is too large: " + res.Skew.String()
+		}
+	}
+
+	res.Checks = append(res.Checks, clock)
+	return res
+}
+
+// script producing "<key> <value>" lines
+func diagnosticScript(columns []string) string {
+	var script []string
+
+	for i, col := range columns {
+		if j := strings.IndexAny(col, ":="); j >= 0 {
+			col = col[:j]
+		}
+
+		script = append(script, "{ ps -o "+shellQuote(col)+" >/dev/null 2>&1 && echo column"+strconv.Itoa(i)+" ok || echo column"+strconv.Itoa(i)+" fail; }")
+	}
+
+	return strings.Join(append(script,
+		"{ cat /proc/1/stat >/dev/null 2>&1 && echo proc ok || echo proc fail; }",
+		"{ cat /proc/1/smaps_rollup >/dev/null 2>&1 && echo details ok || echo details fail; }",
+		"{ sudo -n true >/dev/null 2>&1 && echo sudo ok || echo sudo fail; }",
+		"date '+time %s%N'",
+	), " && ")
+}
+
+func flagCheck(name, result, msg string) Check {
+	if result == "ok" {
+		return Check{Name: name, OK: true}
+	}
+
+	return Check{Name: name, Msg: msg}
+}
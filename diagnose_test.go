@@ -0,0 +1,86 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import "testing"
+
+func TestDiagnose(t *testing.T) {
+	d := Diagnose(nil, "pid", "rss", "xxx")
+
+	if d.Probe == nil {
+		t.Errorf("Probe has failed: %+v", d.Checks)
+		return
+	}
+
+	res := make(map[string]bool, len(d.Checks))
+
+	for _, c := range d.Checks {
+		res[c.Name] = c.OK
+	}
+
+	for _, name := range []string{"ps", "column pid", "column rss", "proc", "clock"} {
+		if !res[name] {
+			t.Errorf("Check %q has failed: %+v", name, d.Checks)
+			return
+		}
+	}
+
+	if ok, found := res["column xxx"]; ok || !found {
+		t.Errorf("Unexpected result of invalid column check: %+v", d.Checks)
+		return
+	}
+
+	if d.OK() {
+		t.Error("Unexpected overall result")
+		return
+	}
+
+	// informational checks do not affect the overall result
+	for _, c := range d.Checks {
+		if info := c.Name == "proc details" || c.Name == "sudo"; c.Info != info {
+			t.Errorf("Unexpected informational flag: %+v", c)
+			return
+		}
+	}
+
+	d = &Diagnosis{Checks: []Check{{Name: "ps", OK: true}, {Name: "sudo", Msg: "no sudo", Info: true}}}
+
+	if !d.OK() {
+		t.Errorf("Unexpected overall result: %+v", d.Checks)
+		return
+	}
+
+	// failed probe
+	d = Diagnose([]string{"sh", "-c", "echo 'ssh: connect to host x port 22: Connection refused' >&2; exit 255"})
+
+	if d.Probe != nil || len(d.Checks) != 1 || d.Checks[0].Name != "connect" {
+		t.Errorf("Unexpected result of failed probe: %+v", d.Checks)
+		return
+	}
+}
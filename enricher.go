@@ -0,0 +1,184 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Enricher is an extension for collecting data not available from 'ps'. The command of each enricher
+// is run on the target machine after 'ps', as a part of the same shell script, and each line of its output
+// is passed to the Parse() method. After the process tree is built, the Attach() method of each enricher
+// is called, in the order of the enrichers in Options.Enrichers, to add the collected data to the snapshot.
+// An enricher keeps its state between the calls, so the same enricher must not be used by more than one
+// collection at a time.
+//
+// Enricher failures (a non-zero exit code of the command, or an error from either Parse() or Attach())
+// do not fail the collection, instead they are reported as snapshot warnings, one per enricher,
+// and whatever data the enricher has collected is still attached. To make an enricher failure
+// fatal wrap the enricher with Mandatory().
+type Enricher interface {
+	// Name returns the enricher name, for error messages.
+	Name() string
+
+	// Command returns the shell command to run on the target machine. It is called once at
+	// the beginning of each collection, so it is also the place to reset the enricher state.
+	// The command's stderr is discarded, and a non-zero exit code is reported as the enricher warning,
+	// or fails the collection if the enricher is Mandatory().
+	Command() string
+
+	// Parse is called for each line of the command output, with leading and trailing
+	// white space removed.
+	Parse(line string) error
+
+	// Attach adds the collected data to the snapshot.
+	Attach(snap *Snapshot) error
+}
+
+// Mandatory wraps the given enricher so that any failure of it results in the collection failure.
+func Mandatory(e Enricher) Enricher {
+	return mandatory{e}
+}
+
+type mandatory struct {
+	Enricher
+}
+
+func isMandatory(e Enricher) bool {
+	_, ok := e.(mandatory)
+	return ok
+}
+
+// list of enrichers for the given options
+func (opts *Options) enrichers() (res []Enricher) {
+	if opts.MemoryDetails {
+		res = append(res, MemoryDetailsEnricher())
+	}
+
+	if opts.SchedStats {
+		res = append(res, SchedStatsEnricher())
+	}
+
+	return append(res, opts.Enrichers...)
+}
+
+// ProcStatsEnricher creates an enricher that adds per-process metrics from the output of the given
+// shell command. Each line of the output should be of the form "<pid> <metric name> <value>". Metrics
+// for processes not in the snapshot are ignored, and so are metrics that are already present in a node.
+func ProcStatsEnricher(name, command string) Enricher {
+	return &procEnricher{name: name, cmd: command}
+}
+
+// MemoryDetailsEnricher creates an enricher for per-process memory metrics not available from 'ps':
+// "SWAP" (swapped out memory size from /proc/<pid>/status) and "DIRTY" (the size of dirty pages from
+// /proc/<pid>/smaps_rollup, Linux 4.14 and later), both in KiB. The metrics are only added for the processes
+// whose data are readable by the user.
+func MemoryDetailsEnricher() Enricher {
+	return ProcStatsEnricher("memory details",
+		"grep -H -e ^VmSwap: -e _Dirty: /proc/[0-9]*/status /proc/[0-9]*/smaps_rollup | awk -F '[/:]' '"+memDetailsScript+"'")
+}
+
+// awk script extracting swap and dirty memory sizes from 'grep -H' output like "/proc/1/status:VmSwap:  0 kB";
+// unlike reading the files directly from awk, 'grep' does not stop on a file that cannot be read
+const memDetailsScript = `{ split($6, val, " ") }
+$5 == "VmSwap" { print $3, "SWAP", val[1] }
+$5 ~ /_Dirty$/ { dirty[$3] += val[1] }
+END { for(pid in dirty) print pid, "DIRTY", dirty[pid] }`
+
+// SchedStatsEnricher creates an enricher for per-process scheduler metrics: "SCHED_RUN" (time spent on CPU,
+// in nanoseconds), "SCHED_WAIT" (time spent waiting on a run queue, in nanoseconds), "SCHED_SLICES" (number
+// of time slices run on CPU), all from /proc/<pid>/schedstat, and "VCSW" and "NVCSW" (numbers of voluntary
+// and non-voluntary context switches) from /proc/<pid>/status. The counters are cumulative since the process
+// start, so rates can be obtained by comparing two consecutive snapshots.
+func SchedStatsEnricher() Enricher {
+	return ProcStatsEnricher("scheduler statistics",
+		"{ grep -H '' /proc/[0-9]*/schedstat; grep -H ctxt_switches: /proc/[0-9]*/status; } | awk -F '[/:]' '"+schedStatsScript+"'")
+}
+
+// awk script extracting scheduler statistics from 'grep -H' output
+const schedStatsScript = `{ split($NF, val, " ") }
+$4 == "schedstat" { print $3, "SCHED_RUN", val[1]; print $3, "SCHED_WAIT", val[2]; print $3, "SCHED_SLICES", val[3] }
+$5 == "voluntary_ctxt_switches" { print $3, "VCSW", val[1] }
+$5 == "nonvoluntary_ctxt_switches" { print $3, "NVCSW", val[1] }`
+
+// enricher for per-process metrics
+type procEnricher struct {
+	name, cmd string
+	stats     map[int]map[string]string
+}
+
+func (e *procEnricher) Name() string {
+	return e.name
+}
+
+func (e *procEnricher) Command() string {
+	e.stats = nil
+	return e.cmd
+}
+
+func (e *procEnricher) Parse(line string) error {
+	f := wsRe.Split(line, 3)
+
+	if len(f) != 3 {
+		return errors.New("Invalid line: " + strconv.Quote(line))
+	}
+
+	pid, err := strconv.Atoi(f[0])
+
+	if err != nil || pid < 0 {
+		return errors.New("Invalid pid: " + strconv.Quote(f[0]))
+	}
+
+	if e.stats == nil {
+		e.stats = make(map[int]map[string]string, 100)
+	}
+
+	if m := e.stats[pid]; m != nil {
+		m[f[1]] = strings.TrimSpace(f[2])
+	} else {
+		e.stats[pid] = map[string]string{f[1]: strings.TrimSpace(f[2])}
+	}
+
+	return nil
+}
+
+func (e *procEnricher) Attach(snap *Snapshot) error {
+	snap.forEach(func(node *ProcNode) {
+		for key, val := range e.stats[node.Pid] {
+			if _, ok := node.Stats[key]; !ok {
+				node.Stats[key] = val
+			}
+		}
+	})
+
+	e.stats = nil
+	return nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnrichers(t *testing.T) {
+	var lines lineCounter
+
+	opts := &Options{
+		Enrichers: []Enricher{
+			ProcStatsEnricher("test", "echo 1 FOO 'bar baz'; echo 1 RSS 0"),
+			&lines,
+		},
+	}
+
+	if s := Script(opts, "rss"); !strings.Contains(s, ` && { ( echo 1 FOO 'bar baz'; echo 1 RSS 0 ) 2>/dev/null; echo "@exit $?"; } | sed 's/^/@0 /'`) {
+		t.Errorf("Invalid script: %q", s)
+		return
+	}
+
+	snap, err := Collect(nil, opts, "rss")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if s := snap.Root.Stats["FOO"]; s != "bar baz" {
+		t.Errorf("Unexpected value of enriched metric: %q", s)
+		return
+	}
+
+	if s := snap.Root.Stats["RSS"]; s == "0" {
+		t.Error("Existing metric is overwritten")
+		return
+	}
+
+	if lines.attached != 2 {
+		t.Errorf("Unexpected number of lines: %d instead of 2", lines.attached)
+		return
+	}
+}
+
+type lineCounter struct {
+	count, attached int
+}
+
+func (c *lineCounter) Name() string { return "line counter" }
+
+func (c *lineCounter) Command() string {
+	c.count = 0
+	return `printf 'a\nb\n'`
+}
+
+func (c *lineCounter) Parse(_ string) error {
+	c.count++
+	return nil
+}
+
+func (c *lineCounter) Attach(_ *Snapshot) error {
+	c.attached = c.count
+	return nil
+}
+
+func TestEnricherFailures(t *testing.T) {
+	type test struct {
+		enricher Enricher
+		warning  string
+	}
+
+	tests := []test{
+		{ProcStatsEnricher("bad output", "echo 1 FOO bar; echo xxx; echo 1 BAR baz"), `Enricher "bad output": line `},
+		{ProcStatsEnricher("exit code", "echo 1 FOO bar; echo 1 BAR baz; exit 3"), `Enricher "exit code": line `},
+	}
+
+	for _, tst := range tests {
+		snap, err := Collect(nil, &Options{Enrichers: []Enricher{tst.enricher}}, "rss")
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(snap.Warnings) != 1 || !strings.HasPrefix(snap.Warnings[0], tst.warning) {
+			t.Errorf("Unexpected warnings: %q", snap.Warnings)
+			return
+		}
+
+		if snap.Root.Stats["FOO"] != "bar" || snap.Root.Stats["BAR"] != "baz" {
+			t.Errorf("Partial data is not attached: %v", snap.Root.Stats)
+			return
+		}
+
+		if _, err = Collect(nil, &Options{Enrichers: []Enricher{Mandatory(tst.enricher)}}, "rss"); err == nil {
+			t.Errorf("Failure of mandatory enricher %q is not detected", tst.enricher.Name())
+			return
+		}
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"encoding/json"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// SnapshotVar is an expvar.Var holding the most recent successfully collected Snapshot, along with
+// some statistics on the collections. Its value appears on the standard /debug/vars endpoint as a JSON
+// object with the following fields: "Snapshot" (null until the first successful collection),
+// "Collections" (total number of collections), "Errors" (number of failed collections),
+// "LastError" (the most recent error message, if any), and "LastDuration" (the duration
+// of the most recent collection, in seconds). A SnapshotVar is safe for concurrent use.
+type SnapshotVar struct {
+	lock sync.Mutex
+	snap *Snapshot
+	stat snapshotVarStat
+}
+
+type snapshotVarStat struct {
+	Collections, Errors int64
+	LastError           string
+	LastDuration        float64
+}
+
+// PublishSnapshot creates a new SnapshotVar and publishes it under the given name. Like expvar.Publish(),
+// it panics if the name is already in use. The returned variable should be passed to Collect()
+// via Options.Publish.
+func PublishSnapshot(name string) *SnapshotVar {
+	v := new(SnapshotVar)
+
+	expvar.Publish(name, v)
+	return v
+}
+
+// Snapshot returns the most recent successfully collected Snapshot, or nil.
+func (v *SnapshotVar) Snapshot() *Snapshot {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.snap
+}
+
+// String implements expvar.Var interface.
+func (v *SnapshotVar) String() string {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	s, err := json.Marshal(struct {
+		Snapshot *Snapshot
+		snapshotVarStat
+	}{v.snap, v.stat})
+
+	if err != nil {
+		// should never happen
+		return "null"
+	}
+
+	return string(s)
+}
+
+func (v *SnapshotVar) update(snap *Snapshot, err error, dur time.Duration) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.stat.Collections++
+	v.stat.LastDuration = dur.Seconds()
+
+	if err != nil {
+		v.stat.Errors++
+		v.stat.LastError = err.Error()
+	} else {
+		v.snap = snap
+	}
+}
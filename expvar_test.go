@@ -0,0 +1,70 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPublishSnapshot(t *testing.T) {
+	v := PublishSnapshot("rstat-test")
+	opts := &Options{Publish: v}
+
+	if _, err := collect(cat("valid-data"), opts); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := collect(cat("no-pid-1"), opts); err == nil {
+		t.Error("Missing PID 1 is not detected")
+		return
+	}
+
+	var res struct {
+		Snapshot            *Snapshot
+		Collections, Errors int
+		LastError           string
+	}
+
+	if err := json.Unmarshal([]byte(v.String()), &res); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res.Collections != 2 || res.Errors != 1 || len(res.LastError) == 0 {
+		t.Errorf("Unexpected statistics: %+v", res)
+		return
+	}
+
+	if res.Snapshot == nil || res.Snapshot.Root == nil || res.Snapshot.Root.Pid != 1 {
+		t.Error("Invalid snapshot")
+		return
+	}
+}
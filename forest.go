@@ -0,0 +1,67 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+// MergeForest combines the process trees from several hosts (given as a map from host name
+// to tree root) into one tree, with each host's tree attached to a synthetic per-host node,
+// and all the per-host nodes attached to one synthetic root, so that the whole fleet can be
+// traversed, rendered, or queried as a single tree. The synthetic root has pid 0 and the only metric
+// "CMD" with the value "fleet". See MergeForestUnder() for details.
+func MergeForest(trees map[string]*ProcNode) *ProcNode {
+	return MergeForestUnder(&ProcNode{Stats: map[string]string{"CMD": "fleet"}}, trees)
+}
+
+// MergeForestUnder is the same as MergeForest(), but with the given synthetic root. The per-host nodes
+// are appended to the list of children of the root, in the order of host names, and they are given
+// negative pids (-1, -2, and so on) to distinguish them from real processes, and two metrics: "HOST"
+// and "CMD", both with the host name as the value. The host trees are not copied, and not modified.
+// A host with a nil tree (like one where the collection has failed) gets its node without children.
+func MergeForestUnder(root *ProcNode, trees map[string]*ProcNode) *ProcNode {
+	names := make(map[string]string, len(trees))
+
+	for name := range trees {
+		names[name] = name
+	}
+
+	for i, name := range sortedKeys(names) {
+		host := &ProcNode{
+			Pid:       -(i + 1),
+			ParentPid: root.Pid,
+			Stats:     map[string]string{"HOST": name, "CMD": name},
+		}
+
+		if tree := trees[name]; tree != nil {
+			host.Children = []*ProcNode{tree}
+		}
+
+		root.Children = append(root.Children, host)
+	}
+
+	return root
+}
@@ -0,0 +1,96 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import "testing"
+
+func TestMergeForest(t *testing.T) {
+	a, err := pstree(cat("valid-data"))
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	b, err := pstree(cat("valid-data"))
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	root := MergeForest(map[string]*ProcNode{"rpi-b": b, "rpi-a": a})
+
+	if n := root.ProcessCount(); n != 2*a.ProcessCount()+3 {
+		t.Errorf("Unexpected number of nodes: %d", n)
+		return
+	}
+
+	if n := root.TotalRSS(); n != 2*84932 {
+		t.Errorf("Unexpected total RSS: %d", n)
+		return
+	}
+
+	if len(root.Children) != 2 || root.Children[0].Stats["HOST"] != "rpi-a" || root.Children[0].Pid != -1 ||
+		root.Children[0].Children[0] != a || root.Children[1].Children[0] != b {
+		t.Error("Unexpected forest structure")
+		return
+	}
+
+	if a.ParentPid != 0 {
+		t.Errorf("Host tree is modified: parent pid %d", a.ParentPid)
+		return
+	}
+}
+
+func TestMergeForestNil(t *testing.T) {
+	a, err := pstree(cat("valid-data"))
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	root := MergeForest(map[string]*ProcNode{"rpi-a": a, "rpi-b": nil})
+
+	// must not panic
+	var count int
+
+	root.ForEach(func(*ProcNode) { count++ })
+
+	if count != a.ProcessCount()+3 {
+		t.Errorf("Unexpected number of nodes: %d", count)
+		return
+	}
+
+	if node := root.Find(func(node *ProcNode) bool { return node.Stats["HOST"] == "rpi-b" }); node == nil || len(node.Children) != 0 {
+		t.Errorf("Unexpected host node: %+v", node)
+		return
+	}
+}
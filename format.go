@@ -0,0 +1,155 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"strconv"
+	"time"
+)
+
+// Align specifies the alignment of a column.
+type Align int
+
+// Column alignments.
+const (
+	AlignAuto  Align = iota // right for numeric columns, left for others
+	AlignLeft               // left alignment
+	AlignRight              // right alignment
+)
+
+// ANSI color codes, for use in thresholds.
+const (
+	ColorRed    = "31"
+	ColorGreen  = "32"
+	ColorYellow = "33"
+	ColorBlue   = "34"
+	ColorBold   = "1"
+)
+
+// Threshold assigns a color to the numeric values above the given limit.
+type Threshold struct {
+	Above float64
+	Color string // ANSI SGR parameters, like ColorRed or "1;31"
+}
+
+// ColumnFormat describes the formatting of a column in text output.
+type ColumnFormat struct {
+	// Column alignment.
+	Align Align
+
+	// Optional conversion of the values for display, like HumanKiB or HumanDuration.
+	// Missing values are not converted.
+	Render func(string) string
+
+	// Color thresholds, applied to the original numeric values. Of all the thresholds
+	// exceeded by the value the one with the highest limit wins.
+	Colors []Threshold
+}
+
+func (f *ColumnFormat) render(s string) string {
+	if f.Render == nil || s == "-" {
+		return s
+	}
+
+	return f.Render(s)
+}
+
+func (f *ColumnFormat) color(s string) (color string) {
+	val, err := strconv.ParseFloat(s, 64)
+
+	if err != nil {
+		return
+	}
+
+	var limit float64
+
+	for _, t := range f.Colors {
+		if val > t.Above && (len(color) == 0 || t.Above > limit) {
+			color, limit = t.Color, t.Above
+		}
+	}
+
+	return
+}
+
+// HumanKiB converts a size in KiB (as in RSS or VSZ columns) to a short human-readable form,
+// like "512K", "3.7M", or "12G". Non-numeric values are returned unchanged.
+func HumanKiB(s string) string {
+	val, err := strconv.ParseFloat(s, 64)
+
+	if err != nil || val < 0 {
+		return s
+	}
+
+	const units = "KMGTP"
+
+	i := 0
+
+	for ; val >= 1024 && i < len(units)-1; i++ {
+		val /= 1024
+	}
+
+	if i > 0 && val < 10 {
+		return strconv.FormatFloat(val, 'f', 1, 64) + units[i:i+1]
+	}
+
+	return strconv.FormatFloat(val, 'f', 0, 64) + units[i:i+1]
+}
+
+// HumanDuration converts a duration in either "etimes" (seconds) or "etime" ([[dd-]hh:]mm:ss) format
+// to a short human-readable form, like "45s", "12m30s", "5h07m", or "3d04h". Values in other formats
+// are returned unchanged.
+func HumanDuration(s string) string {
+	d, ok := parseElapsed(s)
+
+	if !ok {
+		return s
+	}
+
+	secs := int64(d / time.Second)
+
+	switch {
+	case secs < 60:
+		return strconv.FormatInt(secs, 10) + "s"
+	case secs < 3600:
+		return strconv.FormatInt(secs/60, 10) + "m" + twoDigits(secs%60) + "s"
+	case secs < 86400:
+		return strconv.FormatInt(secs/3600, 10) + "h" + twoDigits(secs%3600/60) + "m"
+	default:
+		return strconv.FormatInt(secs/86400, 10) + "d" + twoDigits(secs%86400/3600) + "h"
+	}
+}
+
+func twoDigits(n int64) string {
+	if n < 10 {
+		return "0" + strconv.FormatInt(n, 10)
+	}
+
+	return strconv.FormatInt(n, 10)
+}
@@ -0,0 +1,99 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestHumanFormats(t *testing.T) {
+	tests := []struct {
+		fn       func(string) string
+		src, exp string
+	}{
+		{HumanKiB, "512", "512K"},
+		{HumanKiB, "3828", "3.7M"},
+		{HumanKiB, "12582912", "12G"},
+		{HumanKiB, "-", "-"},
+		{HumanDuration, "45", "45s"},
+		{HumanDuration, "750", "12m30s"},
+		{HumanDuration, "05:07:00", "5h07m"},
+		{HumanDuration, "3-04:00:00", "3d04h"},
+		{HumanDuration, "Aug20", "Aug20"},
+	}
+
+	for _, tst := range tests {
+		if s := tst.fn(tst.src); s != tst.exp {
+			t.Errorf("Unexpected result for %q: %q instead of %q", tst.src, s, tst.exp)
+		}
+	}
+}
+
+func TestTextFormat(t *testing.T) {
+	src := "PID PPID %CPU RSS CMD\n1 0 0.5 3828 /sbin/init\n117 1 92 - sh\n2233 117 50 512 sshd: pi\n"
+
+	snap, err := Parse(strings.NewReader(src), nil)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	text := Text{
+		Columns: []string{"PID", "%CPU", "RSS", "CMD"},
+		Format: map[string]ColumnFormat{
+			"PID": {Align: AlignLeft},
+			"%CPU": {Colors: []Threshold{
+				{Above: 80, Color: ColorRed},
+				{Above: 40, Color: ColorYellow},
+			}},
+			"RSS": {Render: HumanKiB},
+		},
+		Color: true,
+	}
+
+	var buf bytes.Buffer
+
+	if err = text.Write(&buf, snap); err != nil {
+		t.Error(err)
+		return
+	}
+
+	const exp = "PID  %CPU  RSS CMD\n" +
+		"1     0.5 3.7M /sbin/init\n" +
+		"117    \x1b[31m92\x1b[0m    - sh\n" +
+		"2233   \x1b[33m50\x1b[0m 512K sshd: pi\n"
+
+	if s := buf.String(); s != exp {
+		t.Errorf("Unexpected output:\n%q", s)
+		return
+	}
+}
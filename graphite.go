@@ -0,0 +1,134 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteGraphite writes the given metrics of every process in the snapshot to 'w' using Graphite
+// plaintext protocol, one line per metric, with metric paths of the form
+// "hosts.<host>.proc.<pid>.<command name>.<metric>", for example, "hosts.rpi.proc.117.systemd-journald.rss".
+// Only metrics with numeric values are written, and an empty list of metrics selects all
+// numeric metrics. The leading '%' is removed from metric names, so "%CPU" becomes "cpu".
+// The timestamp for all the lines is the snapshot time.
+func WriteGraphite(w io.Writer, host string, snap *Snapshot, metrics ...string) error {
+	out := bufio.NewWriter(w)
+	prefix := "hosts." + graphiteName(host) + ".proc."
+	ts := " " + strconv.FormatInt(snap.Time.Unix(), 10) + "\n"
+
+	snap.Root.ForEach(func(node *ProcNode) {
+		keys := metrics
+
+		if len(keys) == 0 {
+			keys = sortedKeys(node.Stats)
+		}
+
+		p := prefix + strconv.Itoa(node.Pid) + "." + graphiteName(procName(node)) + "."
+
+		for _, key := range keys {
+			if val, ok := node.Float(key); ok {
+				out.WriteString(p + graphiteName(strings.TrimPrefix(key, "%")) + " ")
+				out.WriteString(formatFloat(val) + ts)
+			}
+		}
+	})
+
+	return out.Flush()
+}
+
+// SendGraphite connects to the Graphite (Carbon) server at the given TCP address, and sends
+// the snapshot as described for WriteGraphite(). The timeout applies to the whole operation,
+// zero timeout means no timeout.
+func SendGraphite(addr, host string, snap *Snapshot, timeout time.Duration, metrics ...string) error {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
+	}
+
+	if err = WriteGraphite(conn, host, snap, metrics...); err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// makes a valid Graphite path component from the given string
+func graphiteName(s string) string {
+	return strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_':
+			return c
+		case c >= 'A' && c <= 'Z':
+			return c + ('a' - 'A')
+		default:
+			return '_'
+		}
+	}, s)
+}
+
+// returns the process name, which is the base name of the command, or "unknown"
+func procName(node *ProcNode) string {
+	for _, key := range [...]string{"CMD", "COMMAND"} {
+		if fields := strings.Fields(node.Stats[key]); len(fields) > 0 {
+			if name := path.Base(strings.TrimSuffix(fields[0], ":")); name != "." && name != "/" {
+				return name
+			}
+		}
+	}
+
+	return "unknown"
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,72 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteGraphite(t *testing.T) {
+	snap, err := collect(cat("valid-data"), nil)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	snap.Time = time.Unix(1534775521, 0)
+
+	var buf bytes.Buffer
+
+	if err = WriteGraphite(&buf, "rpi.local", snap, "RSS", "CMD"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	if len(lines) != 23 {
+		t.Errorf("Unexpected number of lines: %d instead of 23", len(lines))
+		return
+	}
+
+	for _, exp := range []string{
+		"hosts.rpi_local.proc.117.systemd-journald.rss 4296 1534775521",
+		"hosts.rpi_local.proc.346.avahi-daemon.rss 2584 1534775521",
+		"hosts.rpi_local.proc.2242._sd-pam_.rss 2064 1534775521",
+	} {
+		if !strings.Contains(buf.String(), exp+"\n") {
+			t.Errorf("Line not found: %q", exp)
+			return
+		}
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+)
+
+// IdentityFunc computes the identity of a process, that is, a string that stays the same when
+// the process is restarted with a new pid. Processes with the same identity are considered
+// the same service, and an empty identity means that the process cannot be identified.
+type IdentityFunc func(node *ProcNode) string
+
+// Fingerprint is the default IdentityFunc. It combines the user, the executable path, and the hash
+// of the command line arguments, like "root:/usr/sbin/sshd:6f1ed002". The command is taken from
+// the column CMD (or COMMAND, or ARGS), and the user from the column USER (or UID, or EUSER).
+// An empty string is returned if the command is not available.
+func Fingerprint(node *ProcNode) string {
+	cmd := firstValue(node, "CMD", "COMMAND", "ARGS")
+
+	if len(cmd) == 0 {
+		return ""
+	}
+
+	args := strings.Fields(cmd)
+	hash := sha1.Sum([]byte(strings.Join(args[1:], " ")))
+
+	return firstValue(node, "USER", "UID", "EUSER") + ":" + args[0] + ":" + hex.EncodeToString(hash[:4])
+}
+
+// IdentityOf returns an IdentityFunc that combines the values of the given columns.
+// The identity is empty if none of the columns is present.
+func IdentityOf(columns ...string) IdentityFunc {
+	return func(node *ProcNode) string {
+		var found bool
+
+		vals := make([]string, len(columns))
+
+		for i, col := range columns {
+			var ok bool
+
+			if vals[i], ok = node.Value(col); ok {
+				found = true
+			}
+		}
+
+		if !found {
+			return ""
+		}
+
+		return strings.Join(vals, ":")
+	}
+}
+
+// Identities groups the processes from the snapshot (including the unparented ones) by their identities,
+// as computed by the given function (nil means Fingerprint). Processes without identity are skipped.
+func (snap *Snapshot) Identities(id IdentityFunc) map[string][]*ProcNode {
+	if id == nil {
+		id = Fingerprint
+	}
+
+	res := make(map[string][]*ProcNode)
+
+	snap.forEach(func(node *ProcNode) {
+		if key := id(node); len(key) > 0 {
+			res[key] = append(res[key], node)
+		}
+	})
+
+	return res
+}
+
+// returns the first present value from the given columns, or an empty string
+func firstValue(node *ProcNode, columns ...string) string {
+	for _, col := range columns {
+		if val, ok := node.Value(col); ok {
+			return val
+		}
+	}
+
+	return ""
+}
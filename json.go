@@ -0,0 +1,66 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import "encoding/json"
+
+// StableJSON serializes the snapshot to JSON with deterministic ordering: the metrics are ordered
+// by name, and the children of each process, as well as the unparented processes, by pid. With a non-empty
+// indent string the output is indented, one element per line, which makes snapshots checked into
+// repositories or compared with textual diff tools produce minimal diffs. The snapshot is not modified.
+func (snap *Snapshot) StableJSON(indent string) ([]byte, error) {
+	res := *snap
+
+	res.Root = sortedTree(snap.Root)
+	res.Unparented = nil
+
+	for _, node := range byPid(snap.Unparented) {
+		res.Unparented = append(res.Unparented, sortedTree(node))
+	}
+
+	if len(indent) == 0 {
+		return json.Marshal(&res)
+	}
+
+	return json.MarshalIndent(&res, "", indent)
+}
+
+// copy of the tree with the children sorted by pid; the metrics are shared with the original tree
+// (encoding/json always orders map keys)
+func sortedTree(node *ProcNode) *ProcNode {
+	res := *node
+
+	res.Children = nil
+
+	for _, child := range byPid(node.Children) {
+		res.Children = append(res.Children, sortedTree(child))
+	}
+
+	return &res
+}
@@ -0,0 +1,77 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStableJSON(t *testing.T) {
+	a, err := Parse(strings.NewReader("PID PPID RSS CMD\n1 0 10 init\n30 1 2 b\n20 1 1 a\n40 0 3 k\n2 0 0 kthreadd\n"), nil)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	b, err := Parse(strings.NewReader("PID PPID RSS CMD\n40 0 3 k\n2 0 0 kthreadd\n20 1 1 a\n1 0 10 init\n30 1 2 b\n"), nil)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	b.Time, b.Bytes = a.Time, a.Bytes
+
+	x, err := a.StableJSON("  ")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	y, err := b.StableJSON("  ")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !bytes.Equal(x, y) {
+		t.Errorf("Different JSON:\n%s\n%s", x, y)
+		return
+	}
+
+	if i, j := bytes.Index(x, []byte(`"a"`)), bytes.Index(x, []byte(`"b"`)); i < 0 || j < i {
+		t.Errorf("Children are not sorted:\n%s", x)
+		return
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Markdown is a writer of snapshots as GitHub-flavored Markdown tables, suitable for incident
+// tickets and chat bots. The processes can be filtered, sorted by a metric, and limited in number,
+// and the table can be completed with a row of totals.
+type Markdown struct {
+	// Columns to write, in order, same as in Text.
+	Columns []string
+
+	// Optional filter, only the processes for which it returns 'true' are written.
+	Filter func(*ProcNode) bool
+
+	// Optional metric to sort the processes by, in descending order, like "%CPU";
+	// processes are sorted by pid otherwise.
+	SortBy string
+
+	// Maximum number of processes to write, unlimited if zero.
+	Limit int
+
+	// Columns to sum up in the last row of the table, like "%CPU" or "RSS". The totals are
+	// calculated over the written processes only.
+	Totals []string
+}
+
+// Write writes the snapshot table to the given writer.
+func (m *Markdown) Write(w io.Writer, snap *Snapshot) error {
+	header := m.Columns
+
+	if len(header) == 0 {
+		header = allColumns(snap)
+	}
+
+	var nodes []*ProcNode
+
+	for _, node := range byPid(allNodes(snap)) {
+		if m.Filter == nil || m.Filter(node) {
+			nodes = append(nodes, node)
+		}
+	}
+
+	if len(m.SortBy) > 0 {
+		sort.SliceStable(nodes, func(i, j int) bool {
+			a, _ := nodes[i].Float(m.SortBy)
+			b, _ := nodes[j].Float(m.SortBy)
+
+			return a > b
+		})
+	}
+
+	if m.Limit > 0 && len(nodes) > m.Limit {
+		nodes = nodes[:m.Limit]
+	}
+
+	rows := make([][]string, len(nodes))
+
+	for i, node := range nodes {
+		rows[i] = tableRow(node, header)
+	}
+
+	right := numericColumns(header, rows)
+
+	for _, row := range rows {
+		for i, s := range row {
+			row[i] = markdownEscaper.Replace(s)
+		}
+	}
+
+	if len(m.Totals) > 0 {
+		rows = append(rows, m.totals(header, nodes))
+	}
+
+	out := bufio.NewWriter(w)
+
+	names := make([]string, len(header))
+
+	for i, s := range header {
+		names[i] = markdownEscaper.Replace(s)
+	}
+
+	writeMarkdownRow(out, names)
+
+	for i := range header {
+		if right[i] {
+			out.WriteString("| ---: ")
+		} else {
+			out.WriteString("| --- ")
+		}
+	}
+
+	out.WriteString("|\n")
+
+	for _, row := range rows {
+		writeMarkdownRow(out, row)
+	}
+
+	return out.Flush()
+}
+
+// the row of totals, with the label in the first column
+func (m *Markdown) totals(header []string, nodes []*ProcNode) []string {
+	row := make([]string, len(header))
+
+	for i, col := range header {
+		for _, name := range m.Totals {
+			if name == col {
+				var sum float64
+
+				for _, node := range nodes {
+					if val, ok := node.Float(col); ok {
+						sum += val
+					}
+				}
+
+				row[i] = formatFloat(sum)
+			}
+		}
+	}
+
+	if len(row[0]) == 0 {
+		row[0] = "**Total**"
+	}
+
+	return row
+}
+
+func writeMarkdownRow(out *bufio.Writer, row []string) {
+	for _, s := range row {
+		out.WriteString("| ")
+		out.WriteString(s)
+		out.WriteByte(' ')
+	}
+
+	out.WriteString("|\n")
+}
+
+var markdownEscaper = strings.NewReplacer("|", `\|`, "\\", `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "\n", " ")
@@ -0,0 +1,74 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMarkdown(t *testing.T) {
+	src := "PID PPID %CPU RSS CMD\n1 0 0.5 3828 /sbin/init\n117 1 2.0 - /bin/sh -c a|b\n" +
+		"2233 117 10 5124 sshd: pi\n30 1 0 12 cron -f\n"
+
+	snap, err := Parse(strings.NewReader(src), nil)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	md := Markdown{
+		Columns: []string{"CMD", "PID", "%CPU", "RSS"},
+		Filter:  func(node *ProcNode) bool { return node.Pid > 1 },
+		SortBy:  "%CPU",
+		Limit:   2,
+		Totals:  []string{"%CPU", "RSS"},
+	}
+
+	var buf bytes.Buffer
+
+	if err = md.Write(&buf, snap); err != nil {
+		t.Error(err)
+		return
+	}
+
+	const exp = `| CMD | PID | %CPU | RSS |
+| --- | ---: | ---: | ---: |
+| sshd: pi | 2233 | 10 | 5124 |
+| /bin/sh -c a\|b | 117 | 2.0 | - |
+| **Total** |  | 12 | 5124 |
+`
+
+	if s := buf.String(); s != exp {
+		t.Errorf("Unexpected output:\n%s", s)
+		return
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Value returns the value of the given metric. The second return value is 'false' if the metric
+// is not found, or is reported by 'ps' as unavailable ("-" or blank).
+func (node *ProcNode) Value(key string) (string, bool) {
+	val, ok := node.Stats[key]
+
+	return val, ok && !isMissing(val)
+}
+
+// Float returns the value of the given metric parsed as a floating point number. The second
+// return value is 'false' if the metric is not present (see Value()) or cannot be parsed.
+func (node *ProcNode) Float(key string) (float64, bool) {
+	if s, ok := node.Value(key); ok {
+		val, err := strconv.ParseFloat(s, 64)
+
+		return val, err == nil
+	}
+
+	return 0, false
+}
+
+// Int returns the value of the given metric parsed as an integer. The second
+// return value is 'false' if the metric is not present (see Value()) or cannot be parsed.
+func (node *ProcNode) Int(key string) (int64, bool) {
+	if s, ok := node.Value(key); ok {
+		val, err := strconv.ParseInt(s, 10, 64)
+
+		return val, err == nil
+	}
+
+	return 0, false
+}
+
+// CPU returns the value of "%CPU" metric (as produced by "%cpu" or "pcpu" columns).
+func (node *ProcNode) CPU() (float64, bool) {
+	return node.Float("%CPU")
+}
+
+// Mem returns the value of "%MEM" metric (as produced by "%mem" or "pmem" columns).
+func (node *ProcNode) Mem() (float64, bool) {
+	return node.Float("%MEM")
+}
+
+// RSS returns the resident set size in KiB (as produced by "rss", "rssize", or "rsz" columns).
+func (node *ProcNode) RSS() (int64, bool) {
+	return node.firstInt("RSS", "RSZ")
+}
+
+// Swap returns the size of swapped out memory in KiB (see Options.MemoryDetails).
+func (node *ProcNode) Swap() (int64, bool) {
+	return node.Int("SWAP")
+}
+
+// Dirty returns the size of dirty memory pages in KiB (see Options.MemoryDetails).
+func (node *ProcNode) Dirty() (int64, bool) {
+	return node.Int("DIRTY")
+}
+
+// VSZ returns the virtual memory size in KiB (as produced by "vsz" or "vsize" columns).
+func (node *ProcNode) VSZ() (int64, bool) {
+	return node.Int("VSZ")
+}
+
+// NumThreads returns the number of threads (as produced by "nlwp" or "thcount" columns).
+func (node *ProcNode) NumThreads() (int64, bool) {
+	return node.firstInt("NLWP", "THCNT")
+}
+
+// Age returns the time elapsed since the process was started, from "ELAPSED" metric (as produced
+// by either "etimes" or "etime" columns). Unlike the process start time, the age is not affected
+// by clock skew or adjustments of the system clock on the target machine.
+func (node *ProcNode) Age() (time.Duration, bool) {
+	s, ok := node.Value("ELAPSED")
+
+	if !ok {
+		return 0, false
+	}
+
+	return parseElapsed(s)
+}
+
+// parser for "etimes" and "etime" formats
+func parseElapsed(s string) (time.Duration, bool) {
+	// "etimes" format: seconds
+	if val, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(val) * time.Second, val >= 0
+	}
+
+	// "etime" format: [[dd-]hh:]mm:ss
+	var days, secs int64
+
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		var err error
+
+		if days, err = strconv.ParseInt(s[:i], 10, 64); err != nil || days < 0 {
+			return 0, false
+		}
+
+		s = s[i+1:]
+	}
+
+	parts := strings.Split(s, ":")
+
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+
+	for _, part := range parts {
+		val, err := strconv.ParseInt(part, 10, 64)
+
+		if err != nil || val < 0 {
+			return 0, false
+		}
+
+		secs = secs*60 + val
+	}
+
+	return time.Duration(days*86400+secs) * time.Second, true
+}
+
+func (node *ProcNode) firstInt(keys ...string) (val int64, ok bool) {
+	for _, key := range keys {
+		if val, ok = node.Int(key); ok {
+			break
+		}
+	}
+
+	return
+}
+
+// TotalCPU returns the sum of "%CPU" metric over the subtree. Processes without the metric are ignored.
+func (root *ProcNode) TotalCPU() (total float64) {
+	root.ForEach(func(node *ProcNode) {
+		if val, ok := node.CPU(); ok {
+			total += val
+		}
+	})
+
+	return
+}
+
+// TotalRSS returns the sum of resident set sizes over the subtree, in KiB.
+// Processes without the metric are ignored.
+func (root *ProcNode) TotalRSS() (total int64) {
+	root.ForEach(func(node *ProcNode) {
+		if val, ok := node.RSS(); ok {
+			total += val
+		}
+	})
+
+	return
+}
+
+// TotalThreads returns the total number of threads in the subtree. Processes without
+// the metric are ignored.
+func (root *ProcNode) TotalThreads() (total int64) {
+	root.ForEach(func(node *ProcNode) {
+		if val, ok := node.NumThreads(); ok {
+			total += val
+		}
+	})
+
+	return
+}
+
+// StartedWithin returns the list of processes from the subtree that are younger than the given
+// duration. Processes without age information are ignored (see Age()).
+func (root *ProcNode) StartedWithin(d time.Duration) (res []*ProcNode) {
+	root.ForEach(func(node *ProcNode) {
+		if age, ok := node.Age(); ok && age < d {
+			res = append(res, node)
+		}
+	})
+
+	return
+}
+
+// ProcessCount returns the number of processes in the subtree, including the root.
+func (root *ProcNode) ProcessCount() (count int) {
+	root.ForEach(func(_ *ProcNode) {
+		count++
+	})
+
+	return
+}
@@ -0,0 +1,289 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTotals(t *testing.T) {
+	root, err := pstree(cat("valid-data"))
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n := root.ProcessCount(); n != 23 {
+		t.Errorf("Unexpected number of processes: %d instead of 23", n)
+		return
+	}
+
+	if n := root.TotalRSS(); n != 84932 {
+		t.Errorf("Unexpected total RSS: %d instead of 84932", n)
+		return
+	}
+
+	if n := root.TotalThreads(); n != 0 {
+		t.Errorf("Unexpected total number of threads: %d instead of 0", n)
+		return
+	}
+
+	node := root.Find(func(node *ProcNode) bool { return node.Pid == 2233 })
+
+	if node == nil {
+		t.Error("PID 2233 not found")
+		return
+	}
+
+	if n := node.TotalRSS(); n != 5124+3976+2156 {
+		t.Errorf("Unexpected subtree RSS: %d instead of %d", n, 5124+3976+2156)
+		return
+	}
+}
+
+func TestProcessAge(t *testing.T) {
+	snap, err := collect(cat("process-age"), nil)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if exp := time.Unix(1534751521, 0); !snap.BootTime.Equal(exp) {
+		t.Errorf("Unexpected boot time: %s instead of %s", snap.BootTime, exp)
+		return
+	}
+
+	exp := map[int]time.Duration{
+		1:    ((2*24+18)*3600 + 40*60 + 7) * time.Second,
+		117:  (40*60 + 3) * time.Second,
+		399:  (28*60 + 10) * time.Second,
+		2233: 290 * time.Second,
+	}
+
+	snap.Root.ForEach(func(node *ProcNode) {
+		age, ok := node.Age()
+
+		if ok != (node.Pid != 2245) || age != exp[node.Pid] {
+			t.Errorf("Unexpected age of pid %d: %s (%v)", node.Pid, age, ok)
+		}
+	})
+
+	var pids []int
+
+	for _, node := range snap.Root.StartedWithin(30 * time.Minute) {
+		pids = append(pids, node.Pid)
+	}
+
+	if sort.Ints(pids); len(pids) != 2 || pids[0] != 399 || pids[1] != 2233 {
+		t.Errorf("Unexpected list of recently started processes: %v", pids)
+		return
+	}
+}
+
+func TestMissingValues(t *testing.T) {
+	src := "PID PPID RSS NLWP WCHAN\n1 0 3828 - -\n117 1 - 1 -\n"
+
+	type test struct {
+		opts Options
+		exp  map[int]map[string]string
+	}
+
+	tests := []test{
+		{Options{}, map[int]map[string]string{
+			1:   {"RSS": "3828", "NLWP": "-", "WCHAN": "-"},
+			117: {"RSS": "-", "NLWP": "1", "WCHAN": "-"},
+		}},
+		{Options{Missing: MissingEmpty}, map[int]map[string]string{
+			1:   {"RSS": "3828", "NLWP": "", "WCHAN": ""},
+			117: {"RSS": "", "NLWP": "1", "WCHAN": ""},
+		}},
+		{Options{Missing: MissingSkip, Defaults: map[string]string{"NLWP": "1"}}, map[int]map[string]string{
+			1:   {"RSS": "3828", "NLWP": "1"},
+			117: {"NLWP": "1"},
+		}},
+	}
+
+	for _, tst := range tests {
+		opts := tst.opts
+		snap, err := Parse(strings.NewReader(src), &opts)
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		snap.Root.ForEach(func(node *ProcNode) {
+			if fmt.Sprint(node.Stats) != fmt.Sprint(tst.exp[node.Pid]) {
+				t.Errorf("Unexpected stats for pid %d: %v instead of %v", node.Pid, node.Stats, tst.exp[node.Pid])
+			}
+
+			if _, ok := node.Value("WCHAN"); ok {
+				t.Errorf("Unexpected WCHAN value for pid %d", node.Pid)
+			}
+		})
+
+		if n := snap.Root.TotalRSS(); n != 3828 {
+			t.Errorf("Unexpected total RSS: %d instead of 3828", n)
+			return
+		}
+	}
+}
+
+func TestNormalizedCPU(t *testing.T) {
+	snap, err := collect(cat("cpu-count"), nil)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if snap.NumCPU != 4 {
+		t.Errorf("Unexpected number of CPUs: %d instead of 4", snap.NumCPU)
+		return
+	}
+
+	exp := map[int]float64{1: 0.125, 117: 45}
+
+	snap.Root.ForEach(func(node *ProcNode) {
+		cpu, ok := snap.NormalizedCPU(node)
+
+		if e, found := exp[node.Pid]; ok != found || cpu != e {
+			t.Errorf("Unexpected normalized CPU of pid %d: %f (%v)", node.Pid, cpu, ok)
+		}
+	})
+
+	// local machine
+	if snap, err = Collect(nil, &Options{CPUCount: true}, "%cpu"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if snap.NumCPU <= 0 {
+		t.Errorf("Invalid number of CPUs: %d", snap.NumCPU)
+		return
+	}
+}
+
+func TestMemoryDetails(t *testing.T) {
+	snap, err := collect(cat("memory-details"), &Options{MemoryDetails: true})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	exp := map[int][2]int64{1: {0, -1}, 117: {1024, 208}, 369: {12, -1}}
+
+	snap.Root.ForEach(func(node *ProcNode) {
+		var got [2]int64
+
+		if swap, ok := node.Swap(); ok {
+			got[0] = swap
+		} else {
+			got[0] = -1
+		}
+
+		if dirty, ok := node.Dirty(); ok {
+			got[1] = dirty
+		} else {
+			got[1] = -1
+		}
+
+		if got != exp[node.Pid] {
+			t.Errorf("Unexpected swap and dirty sizes of pid %d: %v instead of %v", node.Pid, got, exp[node.Pid])
+		}
+	})
+
+	// local machine
+	if snap, err = Collect(nil, &Options{MemoryDetails: true}, "rss"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, ok := snap.Root.Swap(); !ok {
+		t.Error("Swap size of pid 1 is not found")
+		return
+	}
+}
+
+func TestSchedStats(t *testing.T) {
+	snap, err := Collect(nil, &Options{SchedStats: true}, "%cpu")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, key := range []string{"SCHED_RUN", "SCHED_WAIT", "SCHED_SLICES", "VCSW", "NVCSW"} {
+		if _, ok := snap.Root.Int(key); !ok {
+			t.Errorf("Metric %q of pid 1 is not found", key)
+			return
+		}
+	}
+}
+
+func TestCanonicalNames(t *testing.T) {
+	if _, err := collect(cat("bsd-style"), nil); err == nil {
+		t.Error("Lowercase PID column is accepted")
+		return
+	}
+
+	snap, err := collect(cat("bsd-style"), &Options{Canonical: true})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n := snap.Root.TotalRSS(); n != 3828+4296 {
+		t.Errorf("Unexpected total RSS: %d instead of %d", n, 3828+4296)
+		return
+	}
+
+	if n := snap.Root.TotalThreads(); n != 4 {
+		t.Errorf("Unexpected total number of threads: %d instead of 4", n)
+		return
+	}
+
+	if cpu := snap.Root.TotalCPU(); cpu != 1.6 {
+		t.Errorf("Unexpected total CPU: %f instead of 1.6", cpu)
+		return
+	}
+
+	if cmd := snap.Root.Stats["CMD"]; cmd != "/sbin/init" {
+		t.Errorf("Unexpected command: %q", cmd)
+		return
+	}
+}
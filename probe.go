@@ -0,0 +1,150 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/maxim2266/strit"
+)
+
+// ProbeResult contains the information about the target, as discovered by Probe().
+type ProbeResult struct {
+	Path    string        // path to 'ps' program on the target
+	Flavor  string        // 'ps' implementation: "procps", "busybox", "toybox", "bsd", or "unknown"
+	Version string        // 'ps' version, if reported
+	Latency time.Duration // round-trip time of the probe
+}
+
+// ProbeError is the error returned from Probe(), with the stage at which the probe has failed.
+type ProbeError struct {
+	// One of: "command" (the ssh command cannot be started), "connect" (no connection to the target),
+	// "auth" (authentication failure), or "ps" ('ps' is either not found or not usable).
+	Stage string
+	Msg   string
+}
+
+func (e *ProbeError) Error() string {
+	return e.Msg
+}
+
+// Probe checks that the target is reachable via the given ssh command (local machine if the command
+// is empty), and that 'ps' program is available there and supports POSIX options, all without
+// collecting the process tree. Any failure is reported as *ProbeError.
+func Probe(ssh []string) (*ProbeResult, error) {
+	var lines []string
+
+	cmd := shellCommand(ssh, probeScript)
+	start := time.Now()
+
+	err := strit.FromCommand(exec.Command(cmd[0], cmd[1:]...))(func(line []byte) error {
+		if s := strings.TrimSpace(string(line)); len(s) > 0 {
+			lines = append(lines, s)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, probeError(err, len(ssh) > 0)
+	}
+
+	if len(lines) == 0 {
+		return nil, &ProbeError{"ps", "Command 'ps' is not found"}
+	}
+
+	res := &ProbeResult{
+		Path:    lines[0],
+		Latency: time.Since(start),
+	}
+
+	res.Flavor, res.Version = psFlavor(lines[1:])
+	return res, nil
+}
+
+// probe script: the path to 'ps', its version, and a check for POSIX options
+const probeScript = `command -v ps || exit 127; ps --version 2>&1 | head -n 2; ps -o pid= >/dev/null`
+
+func probeError(err error, remote bool) error {
+	e, ok := err.(*strit.ExitError)
+
+	if !ok {
+		return &ProbeError{"command", mapCmdError(err).Error()}
+	}
+
+	msg := mapCmdError(err).Error()
+
+	// ssh returns 255 on its own errors, and sshpass returns 5 on invalid password,
+	// and 6 on unknown host key
+	switch {
+	case remote && (e.ExitCode == 5 || strings.Contains(e.Stderr, "Permission denied")):
+		return &ProbeError{"auth", "Authentication failed: " + msg}
+
+	case remote && (e.ExitCode == 255 || e.ExitCode == 6):
+		return &ProbeError{"connect", "Connection failed: " + msg}
+
+	case e.ExitCode == 127:
+		return &ProbeError{"ps", "Command 'ps' is not found"}
+
+	default:
+		return &ProbeError{"ps", "Command 'ps' does not support POSIX options: " + msg}
+	}
+}
+
+// detects 'ps' flavor and version from the output of 'ps --version'
+func psFlavor(lines []string) (flavor, version string) {
+	text := strings.Join(lines, "\n")
+
+	switch {
+	case strings.Contains(text, "procps"):
+		flavor = "procps"
+	case strings.Contains(text, "BusyBox"):
+		flavor = "busybox"
+	case strings.Contains(text, "toybox"):
+		flavor = "toybox"
+	case strings.Contains(text, "illegal option") || strings.Contains(strings.ToLower(text), "usage: ps"):
+		return "bsd", ""
+	default:
+		return "unknown", ""
+	}
+
+	for _, line := range lines {
+		if strings.Contains(line, flavor) || strings.Contains(line, "BusyBox") {
+			version = versionRe.FindString(line)
+			break
+		}
+	}
+
+	return
+}
+
+var versionRe = regexp.MustCompile(`\d+(\.\d+)+`)
@@ -0,0 +1,72 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import "testing"
+
+func TestProbe(t *testing.T) {
+	res, err := Probe(nil)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(res.Path) == 0 || len(res.Flavor) == 0 {
+		t.Errorf("Unexpected probe result: %+v", res)
+		return
+	}
+
+	// fake ssh failing authentication
+	_, err = Probe([]string{"sh", "-c", "echo 'ssh: user@host: Permission denied (publickey).' >&2; exit 255"})
+
+	if e, ok := err.(*ProbeError); !ok || e.Stage != "auth" || e.Msg != "Authentication failed: user@host: Permission denied (publickey)." {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+}
+
+func TestPsFlavor(t *testing.T) {
+	tests := []struct {
+		src             []string
+		flavor, version string
+	}{
+		{[]string{"ps from procps-ng 3.3.17"}, "procps", "3.3.17"},
+		{[]string{"ps: unrecognized option '--version'", "BusyBox v1.36.1 (2023-06-11) multi-call binary."}, "busybox", "1.36.1"},
+		{[]string{"toybox 0.8.9"}, "toybox", "0.8.9"},
+		{[]string{"ps: illegal option -- -", "usage: ps [-AaCcEefhjlMmrSTvwXx]"}, "bsd", ""},
+		{nil, "unknown", ""},
+	}
+
+	for _, tst := range tests {
+		if flavor, version := psFlavor(tst.src); flavor != tst.flavor || version != tst.version {
+			t.Errorf("Unexpected flavor for %q: %q, %q", tst.src, flavor, version)
+		}
+	}
+}
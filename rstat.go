@@ -29,22 +29,33 @@ EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package rstat
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"os/exec"
+	"io"
+	"net"
+	"path"
 	"regexp"
+	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/maxim2266/strit"
 )
 
-// SSHCommand is a simple ssh command builder. Parameters 'host' and 'user' are mandatory,
-// others are optional. The 'passw' parameter, if not empty, creates a command invoking 'sshpass',
-// otherwise an 'ssh' command is produced. The last parameter specifies the ssh connection timeout
-// in seconds, or 0 for using the platform default. It is generally recommended to give this
-// parameter some reasonable value because the default timeout may be just too long.
+// SSHCommand is a simple ssh command builder. Parameter 'host' is mandatory, others are optional.
+// The 'host' may be a host name, an IPv4 address, or an IPv6 address with or without square brackets
+// and zone index; for the latter the brackets are removed and the '-6' flag is added. An empty 'user'
+// leaves the choice of the user name to ssh and its configuration. The 'passw' parameter, if not empty,
+// creates a command invoking 'sshpass', otherwise an 'ssh' command is produced. The last parameter
+// specifies the ssh connection timeout in seconds, or 0 for using the platform default. It is generally
+// recommended to give this parameter some reasonable value because the default timeout may be just too long.
 // In practice the value of 5 seconds is usually suitable for dealing with devices on local network.
 // The function does not validate its input, instead relying on the 'ssh' program to produce an error
 // if something goes wrong.
@@ -55,22 +66,74 @@ func SSHCommand(host, user, passw string, seconds uint) (cmd []string) {
 		cmd = []string{"ssh"}
 	}
 
+	if addr, ok := ipv6Addr(host); ok {
+		cmd = append(cmd, "-6")
+		host = addr
+	}
+
+	cmd = appendTimeout(cmd, seconds)
+
+	if len(user) > 0 {
+		host = user + "@" + host
+	}
+
+	cmd = append(cmd, host)
+	return
+}
+
+// SSHConfigCommand builds an ssh command for the given host alias as defined in an ssh_config file.
+// The 'config' parameter, if not empty, specifies the configuration file to use instead of the
+// default one, so that targets with their own users, keys, and proxies can be reached exactly as
+// they are from the command line. The 'seconds' parameter has the same meaning as in SSHCommand().
+func SSHConfigCommand(alias, config string, seconds uint) (cmd []string) {
+	cmd = []string{"ssh"}
+
+	if len(config) > 0 {
+		cmd = append(cmd, "-F", config)
+	}
+
+	cmd = append(appendTimeout(cmd, seconds), alias)
+	return
+}
+
+func appendTimeout(cmd []string, seconds uint) []string {
 	if seconds > 0 {
 		cmd = append(cmd, "-o", "ConnectTimeout="+strconv.FormatUint(uint64(seconds), 10))
 	}
 
-	cmd = append(cmd, user+"@"+host)
-	return
+	return cmd
+}
+
+// checks if the host is an IPv6 literal, and if so, returns it without brackets
+func ipv6Addr(host string) (string, bool) {
+	if n := len(host); n > 2 && host[0] == '[' && host[n-1] == ']' {
+		host = host[1 : n-1]
+	}
+
+	addr := host
+
+	// zone index, like in "fe80::1%eth0"
+	if i := strings.IndexByte(addr, '%'); i >= 0 {
+		addr = addr[:i]
+	}
+
+	if strings.IndexByte(addr, ':') >= 0 && net.ParseIP(addr) != nil {
+		return host, true
+	}
+
+	return host, false
 }
 
 // ProcNode is the node of the process tree. It contains the process id, parent process id, a map of metrics
 // as produced by 'ps' program, and a list of child nodes. The metrics are represented
 // as a map from column title (as output by 'ps' command) to the metric value as string.
 // Use 'ps L' on the target machine to get the full list of 'ps' format specifiers and column names.
+// The list of threads is only populated when duplicate pids are merged as threads (see DupMergeThreads).
 type ProcNode struct {
 	Pid, ParentPid int
 	Stats          map[string]string
-	Children       []*ProcNode `json:",omitempty"`
+	Threads        []map[string]string `json:",omitempty"`
+	Children       []*ProcNode         `json:",omitempty"`
 }
 
 // ForEach applies the given function to each node of the process tree recursively.
@@ -98,6 +161,50 @@ func (root *ProcNode) Find(pred func(*ProcNode) bool) *ProcNode {
 	return node
 }
 
+// PanicError is the error type for a panic in a user-supplied callback, as recovered by SafeForEach()
+// and SafeFind().
+type PanicError struct {
+	Pid   int         // pid of the node being processed
+	Value interface{} // the value passed to panic()
+	Stack []byte      // stack trace at the point of the panic
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("Panic while processing pid %d: %v", e.Pid, e.Value)
+}
+
+// SafeForEach is the same as ForEach(), except that a panic in the given function stops
+// the traversal and gets returned as a *PanicError.
+func (root *ProcNode) SafeForEach(fn func(*ProcNode)) error {
+	_, err := root.SafeFind(func(node *ProcNode) bool {
+		fn(node)
+		return false
+	})
+
+	return err
+}
+
+// SafeFind is the same as Find(), except that a panic in the given predicate stops
+// the traversal and gets returned as a *PanicError.
+func (root *ProcNode) SafeFind(pred func(*ProcNode) bool) (node *ProcNode, err error) {
+	node = root.Find(func(n *ProcNode) (ok bool) {
+		defer func() {
+			if v := recover(); v != nil {
+				err = &PanicError{Pid: n.Pid, Value: v, Stack: debug.Stack()}
+				ok = true
+			}
+		}()
+
+		return pred(n)
+	})
+
+	if err != nil {
+		node = nil
+	}
+
+	return
+}
+
 func iterNodes(stack [][]*ProcNode, pred func(*ProcNode) bool) (*ProcNode, [][]*ProcNode) {
 	nodes := stack[len(stack)-1]
 
@@ -121,208 +228,1421 @@ func iterNodes(stack [][]*ProcNode, pred func(*ProcNode) bool) (*ProcNode, [][]*
 // in which case the 'ps' command gets invoked on the local machine. The list of columns should include
 // only the standard format specifiers for '-o' option of the 'ps' command on the target machine,
 // try 'ps L' for the full list or consult 'ps' man page. An empty column list results in 'ps -eF'
-// invocation. All the metrics values are returned 'as-is', without any post-processing.
+// invocation. A column may have a width spec, like "user:20", which is kept as given, except for
+// the command column which is never truncated; columns known to get truncated by default (like "user"
+// or "comm") receive a sufficient width automatically. All the metrics values are returned 'as-is',
+// without any post-processing. Duplicate pids do not result in an error, instead only the first entry
+// of each pid is kept (see DupFirstWins).
 func ProcTree(ssh []string, columns ...string) (*ProcNode, error) {
-	return pstree(concat(ssh, makePsCommand(columns)))
+	snap, err := Collect(ssh, &Options{Duplicates: DupFirstWins}, columns...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return snap.Root, nil
 }
 
-func pstree(cmd []string) (*ProcNode, error) {
-	// println(strings.Join(cmd, " "))
+// DupPolicy specifies how to deal with duplicate pids in the 'ps' output, as produced, for example,
+// by 'ps H' listing threads as separate processes, or by a corrupt capture.
+type DupPolicy int
+
+// Duplicate pid policies.
+const (
+	DupError        DupPolicy = iota // fail the collection
+	DupFirstWins                     // keep the first entry, ignore the others
+	DupMergeThreads                  // keep the first entry, add the others to its list of threads
+)
 
-	var parser psParser
+// MissingPolicy specifies how to deal with metric values reported by 'ps' as unavailable.
+type MissingPolicy int
 
-	if err := nonEmptyLines(strit.FromCommand(exec.Command(cmd[0], cmd[1:]...))).Parse(&parser); err != nil {
-		return nil, err
+// Missing value policies.
+const (
+	MissingKeep  MissingPolicy = iota // keep the values as they are
+	MissingEmpty                      // replace the values with empty strings
+	MissingSkip                       // remove the metrics from the map
+)
+
+// Options is a set of optional parameters for process tree collection. The zero value
+// and nil both stand for the default settings.
+type Options struct {
+	Duplicates DupPolicy // duplicate pid policy, DupError by default
+
+	// ColumnWise selects the mode where each column is collected by a separate 'ps' invocation
+	// (all within one shell script), so that metric values containing white space (like "lstart")
+	// can be parsed unambiguously, at the cost of running 'ps' once per column. Processes that appear
+	// or exit in between the invocations are dropped with a warning. The duplicate pid policy does
+	// not apply in this mode, instead any duplicate pid results in an error.
+	ColumnWise bool
+
+	// RemoteClock requests the target's current time to be collected in the same invocation,
+	// for detecting clock skew between the local and the target machines.
+	RemoteClock bool
+
+	// BootTime requests the target's boot time to be collected in the same invocation.
+	BootTime bool
+
+	// CPUCount requests the number of CPUs on the target machine to be collected in the same invocation.
+	CPUCount bool
+
+	// MemoryDetails adds MemoryDetailsEnricher() to the list of enrichers.
+	MemoryDetails bool
+
+	// SchedStats adds SchedStatsEnricher() to the list of enrichers.
+	SchedStats bool
+
+	// Enrichers is the list of enrichers to run after 'ps', in the given order (see Enricher).
+	Enrichers []Enricher
+
+	// Restricted selects the mode for targets with restricted shells (like rbash), or with sudoers
+	// allowlists permitting only exact command lines. In this mode the command is always a single 'ps'
+	// invocation ('ps -e -o ...', without non-POSIX flags) with the columns in the given order, only POSIX
+	// columns are allowed (others are dropped with a warning), width specs are removed, and all the options
+	// requiring a shell script are disabled (see RestrictedModeDisabled() and Options.Disabled()).
+	Restricted bool
+
+	// Canonical requests the metric names to be converted to their canonical form (see CanonicalName()),
+	// so that the metrics from different platforms can be aggregated together.
+	Canonical bool
+
+	// ChildCounts adds synthetic metrics to every process: "CHILDREN" with the number of direct children,
+	// and "DESCENDANTS" with the total number of descendants, so that they can be used like any
+	// other metric, for example, to find fork bombs.
+	ChildCounts bool
+
+	// Compress enables ssh compression (the '-C' flag) for low-bandwidth links. It has no effect
+	// when the ssh command is nil, and it results in a warning if the ssh command is not nil, but
+	// does not invoke 'ssh' program.
+	Compress bool
+
+	// Missing is the policy for metric values reported by 'ps' as unavailable ("-" or blank).
+	Missing MissingPolicy
+
+	// Defaults maps column names (as in 'ps' output header) to the values to use instead
+	// of unavailable ones. The defaults take precedence over the Missing policy.
+	Defaults map[string]string
+
+	// SwitchUser, if not nil, runs the collection on the target machine as another user (see UserSwitch).
+	SwitchUser *UserSwitch
+
+	// Chroot, if not empty, is the directory on the target machine to run the collection in, via 'chroot'
+	// program, for inspecting recovery environments or mounted images. All the programs used by the collection
+	// ('sh', 'ps', and those of the enrichers) are then taken from that directory, and the processes are
+	// read from its 'proc' subdirectory, which must have procfs mounted. Running 'chroot' usually
+	// requires root privileges (see SwitchUser).
+	Chroot string
+
+	// Namespace, if not zero, is the pid (as seen on the target machine) of a process whose pid and mount
+	// namespaces to run the collection in, via 'nsenter' program (see CollectContainer()).
+	Namespace int
+
+	// Limits bound the resources used by the collection (see Limits).
+	Limits Limits
+
+	// Publish, if not nil, receives the result of every collection (see PublishSnapshot).
+	Publish *SnapshotVar
+}
+
+// Limits is the resource budget of a collection, for collectors embedded in constrained environments.
+// Zero value of any field means no limit. When a limit is reached the snapshot is truncated rather than
+// failed: its Truncated flag is set, and a warning is added describing what has been dropped.
+type Limits struct {
+	// MaxProcesses is the maximum number of processes in the snapshot. The processes are taken in the order
+	// of 'ps' output, and the rest are dropped, so the children of the dropped processes may appear
+	// as unparented. In column-wise mode the limit applies to the first table.
+	MaxProcesses int
+
+	// MaxBytes is the maximum number of bytes to read from the command output. The rest of the output
+	// is dropped, including any incomplete table, and the enrichment data following it. In column-wise mode
+	// the processes from the incomplete table are kept, but the columns not yet read are absent.
+	MaxBytes int64
+
+	// MaxEnrichmentTime is the time limit for each enricher command on the target machine,
+	// enforced by 'timeout' program there. The enricher that timed out gets a warning, and the data
+	// it has produced so far is still attached.
+	MaxEnrichmentTime time.Duration
+}
+
+// Snapshot is the result of a process tree collection. Apart from the process tree itself
+// it contains the processes that do not belong to the tree, and a list of warnings about any data
+// issues that did not cause the collection to fail.
+type Snapshot struct {
+	Root *ProcNode
+
+	// Unparented is the list of processes that are not descendants of pid 1, like kernel threads,
+	// or processes whose ancestors are not visible to 'ps'; each of them may have its own children.
+	Unparented []*ProcNode `json:",omitempty"`
+
+	// Time is the local time of the collection. If the remote time is collected as well,
+	// this is the time when the remote timestamp was received.
+	Time time.Time
+
+	// RemoteTime is the time on the target machine, or zero if not requested (see Options.RemoteClock).
+	RemoteTime time.Time
+
+	// BootTime is the boot time of the target machine as seen by its own clock,
+	// or zero if not requested (see Options.BootTime).
+	BootTime time.Time
+
+	// NumCPU is the number of CPUs on the target machine, or zero if not requested (see Options.CPUCount).
+	NumCPU int `json:",omitempty"`
+
+	// Bytes is the number of bytes of the command output, before any compression applied by ssh.
+	Bytes int64
+
+	// Truncated is set when the snapshot is incomplete because of the collection limits (see Limits).
+	Truncated bool `json:",omitempty"`
+
+	Warnings []string `json:",omitempty"`
+}
+
+// applies the given function to every node of the snapshot, including the unparented ones
+func (snap *Snapshot) forEach(fn func(*ProcNode)) {
+	snap.Root.ForEach(fn)
+
+	for _, node := range snap.Unparented {
+		node.ForEach(fn)
+	}
+}
+
+// Skew returns the difference between the remote and the local clocks, or zero if the remote
+// time is not collected. The value includes the network latency, so it is only precise up to
+// the round-trip time to the target.
+func (snap *Snapshot) Skew() time.Duration {
+	if snap.RemoteTime.IsZero() {
+		return 0
 	}
 
-	return buildProcTree(parser.stats)
+	return snap.RemoteTime.Sub(snap.Time)
 }
 
-// 'ps' command builder
-func makePsCommand(columns []string) []string {
-	if len(columns) == 0 {
-		return []string{"ps", "-ewwF"}
+// ToLocal converts a time as seen by the target machine (like a process start time) to the local clock.
+func (snap *Snapshot) ToLocal(remote time.Time) time.Time {
+	return remote.Add(-snap.Skew())
+}
+
+// Uptime returns the uptime of the target machine at the time of the collection, or zero if the boot time
+// is not collected. Without the remote time collected as well the result may be affected by clock skew.
+func (snap *Snapshot) Uptime() time.Duration {
+	if snap.BootTime.IsZero() {
+		return 0
 	}
 
-	// process the column list to remove duplicates
-	var cmd string
+	return snap.Time.Sub(snap.ToLocal(snap.BootTime))
+}
 
-	m := make(map[string]struct{}, len(columns))
+// NormalizedCPU returns the CPU usage of the given process as a percentage of the whole machine
+// capacity, that is, "%CPU" metric divided by the number of CPUs. Unlike "%CPU" (which 'ps' calculates
+// per CPU core, so a multi-threaded process may show well over 100%) the result never exceeds 100%.
+// The second return value is 'false' if either the metric or the number of CPUs is not available.
+func (snap *Snapshot) NormalizedCPU(node *ProcNode) (float64, bool) {
+	cpu, ok := node.CPU()
 
-	for _, c := range columns {
-		// cut off any column width spec
-		if i := strings.IndexByte(c, ':'); i >= 0 {
-			c = c[:i]
-		}
+	if !ok || snap.NumCPU <= 0 {
+		return 0, false
+	}
 
-		// column name substitution string
-		var subst string
+	return cpu / float64(snap.NumCPU), true
+}
 
-		if i := strings.IndexByte(c, '='); i >= 0 {
-			c, subst = c[:i], c[i+1:]
+// StartTime returns the start time of the given process on the local clock, as calculated from
+// the process age (see ProcNode.Age()). The second return value is 'false' if the age is not available.
+func (snap *Snapshot) StartTime(node *ProcNode) (time.Time, bool) {
+	age, ok := node.Age()
+
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return snap.Time.Add(-age), true
+}
+
+// Collect is the same as ProcTree(), but with the given options, and it returns a Snapshot
+// instead of just the root node. Parameter 'opts' can be nil.
+func Collect(ssh []string, opts *Options, columns ...string) (*Snapshot, error) {
+	return collectContext(context.Background(), ssh, opts, columns...)
+}
+
+// Collect() with the command bound to the given context
+func collectContext(ctx context.Context, ssh []string, opts *Options, columns ...string) (*Snapshot, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	if err := opts.SwitchUser.check(ssh, opts.Restricted); err != nil {
+		return nil, err
+	}
+
+	var warnings []string
+
+	if _, ok := compressedSSH(ssh); opts.Compress && !ok {
+		warnings = append(warnings, "Compression is requested, but the command does not invoke ssh")
+	}
+
+	if opts.Restricted {
+		for _, name := range opts.Disabled() {
+			warnings = append(warnings, fmt.Sprintf("Option %s is disabled in restricted mode", name))
 		}
 
-		// see what we've got
-		switch c {
-		// 'cmd' column must be at the end of the list to avoid truncation
-		case "args", "cmd", "command":
-			if len(subst) > 0 {
-				cmd = "cmd=" + subst
-			} else {
-				cmd = "cmd"
-			}
+		_, dropped := makeRestrictedPsCommand(columns)
 
-		// 'pid' and 'ppid' will be added later
-		case "pid", "ppid":
-			// skip
-		default:
-			if len(subst) > 0 {
-				m[c+"="+subst] = struct{}{}
-			} else {
-				m[c] = struct{}{}
-			}
+		for _, c := range dropped {
+			warnings = append(warnings, fmt.Sprintf("Column %q is not available in restricted mode", c))
 		}
 	}
 
-	// build column list
-	res := []string{"ps", "-ewwo", "pid,ppid"}
+	return collectCmd(ctx, makeCommand(ssh, columns, opts), opts, warnings...)
+}
 
-	for c := range m {
-		res = append(res, "-o", c)
+// CollectContainer collects the process tree of a container, given the pid of any of the container processes
+// as seen on the target machine (like the one reported by 'docker inspect -f {{.State.Pid}}'). The collection
+// is run in the pid and mount namespaces of the container via 'nsenter', so it does not depend on any
+// container runtime API. The result is the container-local tree, with the container pids, and the programs
+// used by the collection are taken from the container file system. Since 'nsenter' requires root privileges,
+// 'sudo' is used if the options do not specify another way of switching the user. Parameter 'opts' can be nil,
+// and it is not modified.
+func CollectContainer(ssh []string, pid int, opts *Options, columns ...string) (*Snapshot, error) {
+	if pid <= 0 {
+		return nil, fmt.Errorf("Invalid container pid: %d", pid)
 	}
 
-	if len(cmd) > 0 {
-		res = append(res, "-o", cmd)
+	var o Options
+
+	if opts != nil {
+		o = *opts
 	}
 
-	// done
-	return res
+	if o.Namespace = pid; o.SwitchUser == nil {
+		o.SwitchUser = &UserSwitch{Program: "sudo"}
+	}
+
+	return Collect(ssh, &o, columns...)
 }
 
-// parser for 'ps' output
-type psParser struct {
-	header []string
-	stats  []map[string]string
+// Disabled returns the names of the options that are set, but have no effect because of the restricted mode.
+func (opts *Options) Disabled() (res []string) {
+	if !opts.Restricted {
+		return
+	}
+
+	for _, opt := range opts.scriptOptions() {
+		if opt.set {
+			res = append(res, opt.name)
+		}
+	}
+
+	return
 }
 
-// parser entry point, reads table header
-func (p *psParser) Enter(line []byte) (strit.ParserFunc, error) {
-	p.stats = make([]map[string]string, 0, 100)
+// RestrictedModeDisabled returns the names of all the options that have no effect in the restricted mode,
+// regardless of their values.
+func RestrictedModeDisabled() (res []string) {
+	for _, opt := range (&Options{}).scriptOptions() {
+		res = append(res, opt.name)
+	}
 
-	if p.header = strings.Fields(string(line)); len(p.header) < 2 {
-		return nil, fmt.Errorf("Invalid header in 'ps' output: %q", strings.Join(p.header, " "))
+	return
+}
+
+// option name and whether the option is set
+type optionFlag struct {
+	name string
+	set  bool
+}
+
+// options requiring a shell script on the target machine
+func (opts *Options) scriptOptions() []optionFlag {
+	return []optionFlag{
+		{"ColumnWise", opts.ColumnWise},
+		{"RemoteClock", opts.RemoteClock},
+		{"BootTime", opts.BootTime},
+		{"CPUCount", opts.CPUCount},
+		{"MemoryDetails", opts.MemoryDetails},
+		{"SchedStats", opts.SchedStats},
+		{"Enrichers", len(opts.Enrichers) > 0},
 	}
+}
 
-	//println(strings.Join(p.header, " "))
-	return p.read, nil
+// Command returns the command that Collect() would run with the same parameters, without running it.
+// Together with Script() it allows for auditing exactly what is going to be executed on the target machine.
+// Parameter 'opts' can be nil.
+func Command(ssh []string, opts *Options, columns ...string) []string {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	return makeCommand(ssh, columns, opts)
 }
 
-// reads the 'ps' data after the header
-func (p *psParser) read(line []byte) (strit.ParserFunc, error) {
-	fields := wsRe.Split(string(line), len(p.header))
+// Script returns the command line that Collect() would execute on the target machine with the same
+// parameters, as it is to be interpreted by POSIX shell. Parameter 'opts' can be nil.
+func Script(opts *Options, columns ...string) string {
+	if opts == nil {
+		opts = &Options{}
+	}
 
-	if len(fields) != len(p.header) {
-		return nil, fmt.Errorf("Invalid number of columns (%d instead of %d): %q",
-			len(fields), len(p.header), strings.Join(fields, " "))
+	if !opts.Restricted && opts.SwitchUser == nil && len(opts.Chroot) == 0 && opts.Namespace == 0 {
+		if script := makeScript(columns, opts); len(script) > 0 {
+			return script
+		}
 	}
 
-	m := make(map[string]string, len(p.header))
+	return shellJoin(targetCommand(columns, opts))
+}
 
-	for i, s := range fields {
-		m[p.header[i]] = s
+// full command builder
+func makeCommand(ssh, columns []string, opts *Options) []string {
+	if opts.Compress {
+		ssh, _ = compressedSSH(ssh)
 	}
 
-	p.stats = append(p.stats, m)
-	return p.read, nil
+	cmd := targetCommand(columns, opts)
+
+	if len(ssh) == 0 {
+		return cmd
+	}
+
+	if opts.SwitchUser.pty() {
+		ssh, _ = sshWithFlag(ssh, "-tt")
+	}
+
+	for i, arg := range cmd {
+		cmd[i] = shellQuote(arg)
+	}
+
+	return concat(ssh, cmd)
 }
 
-var wsRe = regexp.MustCompile(`\s+`)
+// builder of the command to run on the target machine
+func targetCommand(columns []string, opts *Options) (cmd []string) {
+	if opts.Restricted {
+		cmd, _ = makeRestrictedPsCommand(columns)
+		return opts.SwitchUser.wrap(opts.enterRoot(cmd))
+	}
 
-// parser finaliser
-func (p *psParser) Done(err error) error {
-	if err != nil {
-		return mapCmdError(err)
+	script := makeScript(columns, opts)
+
+	if opts.SwitchUser.pty() {
+		// the marker separates the password prompt from the output
+		if len(script) == 0 {
+			script = shellJoin(makePsCommand(columns))
+		}
+
+		script = "echo " + beginMarker + " && " + script
 	}
 
-	return nil
+	if len(script) > 0 {
+		cmd = []string{"sh", "-c", script}
+	} else {
+		cmd = makePsCommand(columns)
+	}
+
+	return opts.SwitchUser.wrap(opts.enterRoot(cmd))
 }
 
-// process tree builder
-func buildProcTree(stats []map[string]string) (*ProcNode, error) {
-	// build a map from 'pid' to *ProcNode
-	nodes := make(map[int]*ProcNode, len(stats))
+// wraps the command for running in the namespaces of the given process and in the alternate
+// root directory, if any
+func (opts *Options) enterRoot(cmd []string) []string {
+	if len(opts.Chroot) > 0 {
+		cmd = concat([]string{"chroot", opts.Chroot}, cmd)
+	}
 
-	for _, stat := range stats {
-		node := &ProcNode{Stats: stat}
+	if opts.Namespace > 0 {
+		cmd = concat([]string{"nsenter", "-t", strconv.Itoa(opts.Namespace), "-p", "-m"}, cmd)
+	}
 
-		var err error
+	return cmd
+}
 
-		// pid
-		if node.Pid, err = getPid(stat, "PID"); err != nil {
-			return nil, err
-		}
+// inserts '-C' flag right after 'ssh' program name, returns false if there is no 'ssh' in the command
+func compressedSSH(ssh []string) ([]string, bool) {
+	return sshWithFlag(ssh, "-C")
+}
 
-		// ppid
-		if node.ParentPid, err = getPid(stat, "PPID"); err != nil {
-			return nil, err
+// inserts the flag right after 'ssh' program name, returns false if there is no 'ssh' in the command
+func sshWithFlag(ssh []string, flag string) ([]string, bool) {
+	for i := 0; i < len(ssh); i++ {
+		switch arg := ssh[i]; {
+		case path.Base(arg) == "ssh":
+			return concat(ssh[:i+1], concat([]string{flag}, ssh[i+1:])), true
+		case i > 0 && path.Base(ssh[0]) == "sshpass" && sshpassOptions[arg]:
+			// skip the option value, which may be a password looking like "ssh"
+			i++
 		}
+	}
 
-		delete(stat, "PID")
-		delete(stat, "PPID")
-		nodes[node.Pid] = node
+	return ssh, len(ssh) == 0
+}
+
+// 'sshpass' options taking a value
+var sshpassOptions = map[string]bool{"-p": true, "-f": true, "-d": true, "-P": true}
+
+// shell script builder; returns an empty string if the script is not needed
+func makeScript(columns []string, opts *Options) string {
+	script, post := makePreamble(opts), makeEnrichments(opts)
+
+	switch {
+	case opts.ColumnWise:
+		script = append(script, makeColumnWiseScript(columns))
+	case len(script)+len(post) > 0:
+		script = append(script, shellJoin(makePsCommand(columns)))
+	default:
+		return ""
 	}
 
-	// build process tree
-	for _, node := range nodes {
-		if parent := nodes[node.ParentPid]; parent != nil {
-			parent.Children = append(parent.Children, node)
+	return strings.Join(append(script, post...), " && ")
+}
+
+// enrichment builder; each line of an enricher command output gets prefixed with "@<index> ",
+// where the index identifies the enricher, and the output is followed by the line with the exit code
+// of the command (run in a subshell), like "@<index> @exit 0"
+func makeEnrichments(opts *Options) (script []string) {
+	for i, e := range opts.enrichers() {
+		cmd := e.Command()
+
+		if t := opts.Limits.MaxEnrichmentTime; t > 0 {
+			cmd = "timeout " + strconv.FormatInt(int64((t+time.Second-1)/time.Second), 10) + " sh -c " + shellQuote(cmd)
+		} else {
+			cmd = "( " + cmd + " )"
 		}
-	}
 
-	// return the root (pid 1); this ignores every process that is not a descendant of pid 1,
-	// thus filtering out kernel threads
-	// Q: Is there a way to filter out kernel threads using just 'ps' options?
-	if root := nodes[1]; root != nil {
-		return root, nil
+		script = append(script, `{ `+cmd+` 2>/dev/null; echo "@exit $?"; } | sed 's/^/@`+strconv.Itoa(i)+` /'`)
 	}
 
-	// root not found
-	return nil, errors.New("Root process with pid 1 is not found")
+	return
 }
 
-// reads pid or similar non-negative integer from string map
-func getPid(stats map[string]string, key string) (val int, err error) {
-	str := stats[key]
+// preamble builder; the preamble is a list of commands, each producing a line of the form
+// "@<key> <value>" before the 'ps' output
+func makePreamble(opts *Options) (script []string) {
+	if opts.RemoteClock {
+		script = append(script, "date '+@time %s%N'")
+	}
 
-	if len(str) == 0 {
-		err = fmt.Errorf("Metric %q is not found or empty", key)
-	} else if val, err = strconv.Atoi(str); err != nil {
-		if e, ok := err.(*strconv.NumError); ok {
-			err = fmt.Errorf("Parsing metric %s %q: %s", key, str, e.Err)
-		}
-	} else if val < 0 {
-		err = fmt.Errorf("Parsing metric %s %q: negative value", key, str)
+	if opts.BootTime {
+		script = append(script, "sed -n 's/^btime /@boot /p' /proc/stat")
+	}
+
+	if opts.CPUCount {
+		script = append(script, `echo "@ncpu $(nproc 2>/dev/null || grep -c ^processor /proc/cpuinfo)"`)
 	}
 
 	return
 }
 
-// nonEmptyLines makes a new iterator combining white-space trimming and empty lines filtering
-func nonEmptyLines(iter strit.Iter) strit.Iter {
-	return func(fn strit.Func) error {
-		return iter(func(line []byte) (err error) {
-			if line = bytes.TrimSpace(line); len(line) > 0 {
-				err = fn(line)
-			}
+func pstree(cmd []string) (*ProcNode, error) {
+	snap, err := collect(cmd, nil)
 
-			return
-		})
+	if err != nil {
+		return nil, err
 	}
+
+	return snap.Root, nil
 }
 
-// error mapper for parser
-func mapCmdError(err error) error {
+func collect(cmd []string, opts *Options, warnings ...string) (*Snapshot, error) {
+	return collectCmd(context.Background(), cmd, opts, warnings...)
+}
+
+// the given warnings are added to the snapshot before it is published
+func collectCmd(ctx context.Context, cmd []string, opts *Options, warnings ...string) (*Snapshot, error) {
+	// println(strings.Join(cmd, " "))
+
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	start := time.Now()
+	snap, err := parse(strit.FromCommand(opts.command(ctx, cmd)), opts)
+
+	if err == nil {
+		snap.Warnings = append(snap.Warnings, warnings...)
+	}
+
+	if opts.Publish != nil {
+		opts.Publish.update(snap, err, time.Since(start))
+	}
+
+	return snap, err
+}
+
+// Parse reads 'ps' output from the given reader and builds a Snapshot from it, using the given options
+// (which can be nil). The input must start with the table header, and it must contain "PID" and "PPID"
+// columns, as in the output of 'ps -eF', for example. Malformed input results in a *ParseError.
+func Parse(r io.Reader, opts *Options) (*Snapshot, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	return parse(strit.FromReader(r), opts)
+}
+
+func parse(iter strit.Iter, opts *Options) (*Snapshot, error) {
+	var parser strit.Parser
+	var res *psParser
+
+	start := time.Now()
+
+	if opts.ColumnWise {
+		p := new(colParser)
+		parser, res = p, &p.psParser
+	} else {
+		res = new(psParser)
+		parser = res
+	}
+
+	// enrichers never run in restricted mode
+	if !opts.Restricted {
+		res.enrichers = opts.enrichers()
+	}
+
+	res.skipToBegin = opts.SwitchUser.pty()
+	res.limits = opts.Limits
+
+	if err := res.lines(iter).Parse(parser); err != nil {
+		return nil, err
+	}
+
+	if res.preambleTime.IsZero() {
+		res.preambleTime = start
+	}
+
+	snap, err := buildSnapshot(res, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// enrichment pipeline
+	for _, e := range res.enrichers {
+		if err = e.Attach(snap); err != nil {
+			if isMandatory(e) {
+				return nil, fmt.Errorf("Enricher %q: %s", e.Name(), err)
+			}
+
+			snap.Warnings = append(snap.Warnings, fmt.Sprintf("Enricher %q: %s", e.Name(), err))
+		}
+	}
+
+	return snap, nil
+}
+
+// 'ps' command builder
+func makePsCommand(columns []string) []string {
+	if len(columns) == 0 {
+		return []string{"ps", "-ewwF"}
+	}
+
+	res := []string{"ps", "-ewwo", "pid,ppid"}
+
+	for _, c := range psColumns(columns) {
+		res = append(res, "-o", c)
+	}
+
+	return res
+}
+
+// 'ps' command builder for restricted mode; returns the command and the list of dropped columns
+func makeRestrictedPsCommand(columns []string) (cmd, dropped []string) {
+	if len(columns) == 0 {
+		columns = posixDefaultColumns
+	}
+
+	cmd = []string{"ps", "-e", "-o", "pid,ppid"}
+
+	var args string
+
+	seen := make(map[string]bool, len(columns))
+
+	for _, c := range columns {
+		var subst string
+
+		if i := strings.IndexByte(c, '='); i >= 0 {
+			c, subst = c[:i], c[i+1:]
+		}
+
+		// drop width spec
+		if i := strings.IndexByte(c, ':'); i >= 0 {
+			c = c[:i]
+		}
+
+		switch {
+		case c == "pid" || c == "ppid":
+			// skip
+		case c == "args" || c == "cmd" || c == "command":
+			// must be the last one
+			args = columnSpec("args", "", subst)
+		case !posixColumns[c]:
+			dropped = append(dropped, c)
+		case !seen[c+"="+subst]:
+			seen[c+"="+subst] = true
+			cmd = append(cmd, "-o", columnSpec(c, "", subst))
+		}
+	}
+
+	if len(args) > 0 {
+		cmd = append(cmd, "-o", args)
+	}
+
+	return
+}
+
+// columns defined by POSIX
+var posixColumns = map[string]bool{
+	"ruser": true, "user": true, "rgroup": true, "group": true, "pgid": true, "pcpu": true,
+	"vsz": true, "nice": true, "etime": true, "time": true, "tty": true, "comm": true,
+}
+
+// default columns for restricted mode
+var posixDefaultColumns = []string{"user", "pcpu", "vsz", "tty", "etime", "time", "args"}
+
+// column-wise command builder; it produces a shell script invoking 'ps' once per column,
+// where each 'ps' outputs a table of two columns: pid and the column value
+func makeColumnWiseScript(columns []string) string {
+	if len(columns) == 0 {
+		columns = psDefaultColumns
+	}
+
+	script := "ps -ewwo pid,ppid"
+
+	for _, c := range psColumns(columns) {
+		script += " && ps -ewwo pid -o " + shellQuote(c)
+	}
+
+	return script
+}
+
+// the same metrics as in 'ps -F' output
+var psDefaultColumns = []string{"user", "c", "sz", "rss", "psr", "stime", "tty", "time", "cmd"}
+
+// process the column list to remove duplicates, 'pid' and 'ppid', to move 'cmd' to the end
+// (otherwise keeping the given order, so the command is the same for the same columns),
+// and to manage column widths
+func psColumns(columns []string) []string {
+	var cmd string
+
+	res := make([]string, 0, len(columns)+1)
+	seen := make(map[string]bool, len(columns))
+
+	for _, c := range columns {
+		// column name substitution string
+		var subst string
+
+		if i := strings.IndexByte(c, '='); i >= 0 {
+			c, subst = c[:i], c[i+1:]
+		}
+
+		// column width spec, either as "name:width=header", or as "name=header:width"
+		var width string
+
+		if i := strings.IndexByte(c, ':'); i >= 0 {
+			c, width = c[:i], c[i+1:]
+		} else if i = strings.LastIndexByte(subst, ':'); i >= 0 && isWidth(subst[i+1:]) {
+			subst, width = subst[:i], subst[i+1:]
+		}
+
+		// see what we've got
+		switch c {
+		// 'cmd' column must be at the end of the list to avoid truncation
+		case "args", "cmd", "command":
+			cmd = columnSpec("cmd", "", subst)
+
+		// 'pid' and 'ppid' will be added later
+		case "pid", "ppid":
+			// skip
+		default:
+			if !isWidth(width) {
+				width = psColumnWidths[c]
+			}
+
+			if key := c + "=" + subst; !seen[key] {
+				seen[key] = true
+				res = append(res, columnSpec(c, width, subst))
+			}
+		}
+	}
+
+	// 'cmd' goes last
+	if len(cmd) > 0 {
+		res = append(res, cmd)
+	}
+
+	// done
+	return res
+}
+
+// column widths for the columns known to get truncated by 'ps' by default
+var psColumnWidths = map[string]string{
+	"user": "32", "euser": "32", "ruser": "32", "suser": "32", "fuser": "32", "uname": "32",
+	"group": "32", "egroup": "32", "rgroup": "32", "sgroup": "32", "fgroup": "32",
+	"comm": "64", "ucomm": "64", "ucmd": "64",
+	"wchan": "32", "label": "64",
+}
+
+// checks if the string is a valid column width
+func isWidth(s string) bool {
+	n, err := strconv.Atoi(s)
+
+	return err == nil && n > 0
+}
+
+// composes column spec in "name:width=header" format
+func columnSpec(name, width, subst string) string {
+	if len(width) > 0 {
+		name += ":" + width
+	}
+
+	if len(subst) > 0 {
+		name += "=" + subst
+	}
+
+	return name
+}
+
+// makes a command running the given shell script either locally (if 'ssh' is empty), or remotely
+func shellCommand(ssh []string, script string) []string {
+	if len(ssh) == 0 {
+		return []string{"sh", "-c", script}
+	}
+
+	return concat(ssh, []string{"sh", "-c", shellQuote(script)})
+}
+
+// quotes the string for POSIX shell, if needed
+func shellQuote(s string) string {
+	if len(s) > 0 && strings.Trim(s, shellSafeChars) == "" {
+		return s
+	}
+
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// joins the command arguments into a shell command line
+func shellJoin(args []string) string {
+	res := make([]string, len(args))
+
+	for i, arg := range args {
+		res[i] = shellQuote(arg)
+	}
+
+	return strings.Join(res, " ")
+}
+
+const shellSafeChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789%+,-./:=@_"
+
+// ParseError is the error type for malformed 'ps' output.
+type ParseError struct {
+	Line int    // line number, starting from 1
+	Msg  string // error message
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("Line %d: %s", e.Line, e.Msg)
+}
+
+// pseudo-error stopping the parser when the output size limit is reached
+var errTruncated = errors.New("Output is truncated")
+
+// parser for 'ps' output
+type psParser struct {
+	header   []string
+	stats    []map[string]string
+	lineNo   int   // current line number
+	lineNs   []int // line number for each element of 'stats'
+	bytes    int64 // number of bytes read
+	warnings []string
+
+	preamble     map[string]string // preamble values by key
+	preambleTime time.Time         // local time when the preamble was received
+
+	enrichers []Enricher
+	failed    map[int]bool // enrichers that have produced a warning
+
+	emit func(map[string]string) error // if set, receives each record instead of storing it (see CollectStream)
+
+	skipToBegin bool // skip everything up to the begin marker, like password prompts
+
+	limits    Limits
+	truncated bool // set when any limit is reached
+	dropped   int  // number of processes dropped because of the limit
+}
+
+// parser entry point, reads table header
+func (p *psParser) Enter(line []byte) (strit.ParserFunc, error) {
+	p.stats = make([]map[string]string, 0, 100)
+	p.lineNs = make([]int, 0, 100)
+
+	if p.header = strings.Fields(string(line)); len(p.header) < 2 {
+		return nil, p.errorf("Invalid header in 'ps' output: %q", strings.Join(p.header, " "))
+	}
+
+	seen := make(map[string]struct{}, len(p.header))
+
+	for _, name := range p.header {
+		if strings.IndexFunc(name, unicode.IsControl) >= 0 || !utf8.ValidString(name) {
+			return nil, p.errorf("Invalid column name in 'ps' output header: %q", name)
+		}
+
+		if _, ok := seen[name]; ok {
+			return nil, p.errorf("Duplicate column name in 'ps' output header: %q", name)
+		}
+
+		seen[name] = struct{}{}
+	}
+
+	//println(strings.Join(p.header, " "))
+	return p.read, nil
+}
+
+// reads the 'ps' data after the header
+func (p *psParser) read(line []byte) (strit.ParserFunc, error) {
+	fields := wsRe.Split(string(line), len(p.header))
+
+	if len(fields) != len(p.header) {
+		return nil, p.errorf("Invalid number of columns (%d instead of %d): %q",
+			len(fields), len(p.header), strings.Join(fields, " "))
+	}
+
+	m := make(map[string]string, len(p.header))
+
+	for i, s := range fields {
+		m[p.header[i]] = s
+	}
+
+	if p.emit != nil {
+		return p.read, p.emit(m)
+	}
+
+	if n := p.limits.MaxProcesses; n > 0 && len(p.stats) >= n {
+		p.truncated = true
+		p.dropped++
+		return p.read, nil
+	}
+
+	p.stats = append(p.stats, m)
+	p.lineNs = append(p.lineNs, p.lineNo)
+	return p.read, nil
+}
+
+// lines makes a new iterator combining line counting, white-space trimming and empty lines filtering
+func (p *psParser) lines(iter strit.Iter) strit.Iter {
+	return func(fn strit.Func) error {
+		return iter(func(line []byte) (err error) {
+			p.lineNo++
+
+			if n := p.limits.MaxBytes; n > 0 && p.bytes+int64(len(line))+1 > n {
+				p.truncated = true
+				p.warnings = append(p.warnings, fmt.Sprintf("Output size limit of %d bytes is reached: the rest is dropped", n))
+				return errTruncated
+			}
+
+			p.bytes += int64(len(line)) + 1
+
+			if line = bytes.TrimSpace(line); p.skipToBegin {
+				p.skipToBegin = (string(line) != beginMarker)
+			} else if len(line) > 0 {
+				if line[0] == '@' {
+					err = p.readPreamble(line[1:])
+				} else {
+					err = fn(line)
+				}
+			}
+
+			return
+		})
+	}
+}
+
+// reads one line of either the preamble or the enrichment data
+func (p *psParser) readPreamble(line []byte) error {
+	kv := wsRe.Split(string(line), 2)
+
+	if len(kv) < 2 {
+		kv = append(kv, "")
+	}
+
+	// enrichment data
+	if i, err := strconv.Atoi(kv[0]); err == nil {
+		if i < 0 || i >= len(p.enrichers) {
+			return p.errorf("Unexpected enrichment data: %q", string(line))
+		}
+
+		if strings.HasPrefix(kv[1], "@exit ") {
+			switch code := strings.TrimSpace(kv[1][6:]); {
+			case code == "124" && p.limits.MaxEnrichmentTime > 0:
+				// exit code of 'timeout' program
+				p.truncated = true
+				err = errors.New("Command timed out")
+			case code != "0":
+				err = fmt.Errorf("Command failed with exit code %s", code)
+			}
+		} else {
+			err = p.enrichers[i].Parse(kv[1])
+		}
+
+		if err != nil {
+			return p.enricherError(i, err)
+		}
+
+		return nil
+	}
+
+	// preamble
+	if p.preamble == nil {
+		p.preamble = make(map[string]string, 4)
+		p.preambleTime = time.Now()
+	}
+
+	p.preamble[kv[0]] = kv[1]
+	return nil
+}
+
+// enricher errors are only fatal for mandatory enrichers, otherwise they become warnings,
+// one per enricher
+func (p *psParser) enricherError(i int, err error) error {
+	e := p.enrichers[i]
+
+	if isMandatory(e) {
+		return p.errorf("Enricher %q: %s", e.Name(), err)
+	}
+
+	if p.failed == nil {
+		p.failed = make(map[int]bool, len(p.enrichers))
+	}
+
+	if !p.failed[i] {
+		p.failed[i] = true
+		p.warnings = append(p.warnings, fmt.Sprintf("Enricher %q: line %d: %s", e.Name(), p.lineNo, err))
+	}
+
+	return nil
+}
+
+func (p *psParser) errorf(format string, args ...interface{}) error {
+	return &ParseError{Line: p.lineNo, Msg: fmt.Sprintf(format, args...)}
+}
+
+// parser for column-wise 'ps' output, which is a sequence of tables of two columns each,
+// where the first column is always pid
+type colParser struct {
+	psParser
+	pidCol string                       // pid column name
+	column string                       // current column name
+	ncols  int                          // number of tables seen so far
+	pids   []string                     // pids from the first table, in order
+	rows   map[string]map[string]string // pid -> stats
+	seen   map[string]int               // pid -> number of tables it is found in
+	lines  map[string]int               // pid -> line number in the first table
+}
+
+// parser entry point, reads the header of the first table
+func (p *colParser) Enter(line []byte) (strit.ParserFunc, error) {
+	p.rows = make(map[string]map[string]string, 100)
+	p.seen = make(map[string]int, 100)
+	p.lines = make(map[string]int, 100)
+
+	if err := p.readHeader(line); err != nil {
+		return nil, err
+	}
+
+	p.pidCol = p.header[0]
+	return p.read, nil
+}
+
+// reads table header
+func (p *colParser) readHeader(line []byte) error {
+	if p.header = wsRe.Split(string(line), 2); len(p.header) != 2 {
+		return p.errorf("Invalid header in 'ps' output: %q", string(line))
+	}
+
+	if p.column = p.header[1]; p.column == p.pidCol {
+		return p.errorf("Duplicate column name in 'ps' output header: %q", p.column)
+	}
+
+	for _, row := range p.rows {
+		if _, ok := row[p.column]; ok {
+			return p.errorf("Duplicate column name in 'ps' output header: %q", p.column)
+		}
+	}
+
+	p.ncols++
+	return nil
+}
+
+// reads table row, or the header of the next table
+func (p *colParser) read(line []byte) (strit.ParserFunc, error) {
+	fields := wsRe.Split(string(line), 2)
+
+	if fields[0] == p.pidCol {
+		return p.read, p.readHeader(line)
+	}
+
+	pid, value := fields[0], ""
+
+	if len(fields) > 1 {
+		value = fields[1]
+	}
+
+	if p.ncols == 1 {
+		// first table
+		if _, ok := p.rows[pid]; ok {
+			return nil, p.errorf("Duplicate pid %s", pid)
+		}
+
+		if n := p.limits.MaxProcesses; n > 0 && len(p.pids) >= n {
+			p.truncated = true
+			p.dropped++
+			return p.read, nil
+		}
+
+		p.pids = append(p.pids, pid)
+		p.rows[pid] = map[string]string{p.pidCol: pid, p.column: value}
+		p.seen[pid] = 1
+		p.lines[pid] = p.lineNo
+		return p.read, nil
+	}
+
+	switch row, n := p.rows[pid], p.seen[pid]; {
+	case row == nil || n < p.ncols-1:
+		// the process has appeared after the first table, or disappeared in between
+	case n == p.ncols:
+		return nil, p.errorf("Duplicate pid %s", pid)
+	default:
+		row[p.column] = value
+		p.seen[pid] = p.ncols
+	}
+
+	return p.read, nil
+}
+
+// parser finaliser, keeps only the processes found in every table
+func (p *colParser) Done(err error) error {
+	if err = p.psParser.Done(err); err != nil {
+		return err
+	}
+
+	p.stats = make([]map[string]string, 0, len(p.pids))
+	p.lineNs = make([]int, 0, len(p.pids))
+
+	for _, pid := range p.pids {
+		// with the output truncated the last table may be incomplete
+		if n := p.seen[pid]; n == p.ncols || p.truncated && n == p.ncols-1 && p.limits.MaxBytes > 0 {
+			p.stats = append(p.stats, p.rows[pid])
+			p.lineNs = append(p.lineNs, p.lines[pid])
+		} else {
+			p.warnings = append(p.warnings, fmt.Sprintf("Process %s changed during collection: dropped", pid))
+		}
+	}
+
+	return nil
+}
+
+var wsRe = regexp.MustCompile(`\s+`)
+
+// parser finaliser
+func (p *psParser) Done(err error) error {
+	if p.dropped > 0 {
+		p.warnings = append(p.warnings,
+			fmt.Sprintf("Process limit of %d is reached: %d processes dropped", p.limits.MaxProcesses, p.dropped))
+	}
+
+	if err == errTruncated {
+		return nil
+	}
+
+	if err == bufio.ErrTooLong {
+		return &ParseError{Line: p.lineNo + 1, Msg: "Line is too long"}
+	}
+
+	if err != nil {
+		return mapCmdError(err)
+	}
+
+	return nil
+}
+
+// process tree builder
+func buildSnapshot(p *psParser, opts *Options) (*Snapshot, error) {
+	snap := &Snapshot{Time: p.preambleTime, Bytes: p.bytes, Truncated: p.truncated, Warnings: p.warnings}
+
+	if s, ok := p.preamble["time"]; ok {
+		var err error
+
+		if snap.RemoteTime, err = parseRemoteTime(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s, ok := p.preamble["boot"]; ok {
+		val, err := strconv.ParseInt(s, 10, 64)
+
+		if err != nil || val <= 0 {
+			return nil, fmt.Errorf("Invalid boot time: %q", s)
+		}
+
+		snap.BootTime = time.Unix(val, 0)
+	}
+
+	if s, ok := p.preamble["ncpu"]; ok {
+		var err error
+
+		if snap.NumCPU, err = strconv.Atoi(s); err != nil || snap.NumCPU <= 0 {
+			return nil, fmt.Errorf("Invalid number of CPUs: %q", s)
+		}
+	}
+
+	// build a map from 'pid' to *ProcNode
+	nodes := make(map[int]*ProcNode, len(p.stats))
+
+	for i, stat := range p.stats {
+		if opts.Canonical {
+			canonicalStats(stat)
+		}
+
+		node := &ProcNode{Stats: stat}
+
+		var err error
+
+		// pid
+		if node.Pid, err = getPid(stat, "PID"); err != nil {
+			return nil, &ParseError{Line: p.lineNs[i], Msg: err.Error()}
+		}
+
+		// ppid
+		if node.ParentPid, err = getPid(stat, "PPID"); err != nil {
+			return nil, &ParseError{Line: p.lineNs[i], Msg: err.Error()}
+		}
+
+		delete(stat, "PID")
+		delete(stat, "PPID")
+		fixMissing(stat, opts)
+
+		// duplicates
+		if first := nodes[node.Pid]; first != nil {
+			switch opts.Duplicates {
+			case DupFirstWins:
+				snap.Warnings = append(snap.Warnings, fmt.Sprintf("Duplicate pid %d: entry ignored", node.Pid))
+			case DupMergeThreads:
+				first.Threads = append(first.Threads, stat)
+				snap.Warnings = append(snap.Warnings, fmt.Sprintf("Duplicate pid %d: entry merged as thread", node.Pid))
+			default:
+				return nil, &ParseError{Line: p.lineNs[i], Msg: fmt.Sprintf("Duplicate pid %d", node.Pid)}
+			}
+
+			continue
+		}
+
+		nodes[node.Pid] = node
+	}
+
+	// build process tree; neither pid 1 nor a process listed as its own parent can be a child,
+	// which guarantees the tree rooted at pid 1 has no loops
+	for _, node := range nodes {
+		if node.Pid == 1 || node.Pid == node.ParentPid {
+			continue
+		}
+
+		if parent := nodes[node.ParentPid]; parent != nil {
+			parent.Children = append(parent.Children, node)
+		}
+	}
+
+	// find the root (pid 1)
+	if snap.Root = nodes[1]; snap.Root == nil {
+		return nil, errors.New("Root process with pid 1 is not found")
+	}
+
+	// every process that is not a descendant of pid 1 (like kernel threads) goes to the unparented list
+	snap.Unparented = unparented(nodes)
+
+	if opts.ChildCounts {
+		countDescendants(snap.Root)
+
+		for _, node := range snap.Unparented {
+			countDescendants(node)
+		}
+	}
+
+	return snap, nil
+}
+
+// sets "CHILDREN" and "DESCENDANTS" metrics on every node of the subtree, returning the number of descendants
+func countDescendants(node *ProcNode) (n int) {
+	for _, child := range node.Children {
+		n += countDescendants(child) + 1
+	}
+
+	node.Stats["CHILDREN"] = strconv.Itoa(len(node.Children))
+	node.Stats["DESCENDANTS"] = strconv.Itoa(n)
+	return
+}
+
+// finds the roots of all the subtrees not reachable from pid 1, breaking any parent-child loops
+func unparented(nodes map[int]*ProcNode) (res []*ProcNode) {
+	pids := make([]int, 0, len(nodes))
+
+	for pid := range nodes {
+		pids = append(pids, pid)
+	}
+
+	sort.Ints(pids)
+
+	reached := make(map[int]bool, len(nodes))
+	mark := func(node *ProcNode) {
+		node.ForEach(func(n *ProcNode) {
+			reached[n.Pid] = true
+		})
+	}
+
+	mark(nodes[1])
+
+	// processes without a parent in the list
+	for _, pid := range pids {
+		if node := nodes[pid]; pid != 1 && (pid == node.ParentPid || nodes[node.ParentPid] == nil) {
+			res = append(res, node)
+			mark(node)
+		}
+	}
+
+	// all the remaining processes are in loops
+	for _, pid := range pids {
+		if reached[pid] {
+			continue
+		}
+
+		node := nodes[pid]
+		parent := nodes[node.ParentPid]
+
+		for i, child := range parent.Children {
+			if child == node {
+				parent.Children = append(parent.Children[:i], parent.Children[i+1:]...)
+				break
+			}
+		}
+
+		res = append(res, node)
+		mark(node)
+	}
+
+	return
+}
+
+// CanonicalName converts a 'ps' column header to its canonical form, which is the header produced
+// by Linux 'procps' implementation of 'ps' for the same metric. For example, "rss", "RSZ", and "rssize"
+// all become "RSS", "pcpu" becomes "%CPU", "thcount" becomes "NLWP", and "args" or "COMMAND" become "CMD".
+// The typed accessors like ProcNode.RSS() always work with canonical names.
+func CanonicalName(header string) string {
+	header = strings.ToUpper(header)
+
+	if name, ok := canonicalNames[header]; ok {
+		return name
+	}
+
+	return header
+}
+
+var canonicalNames = map[string]string{
+	"RSZ":     "RSS",
+	"RSSIZE":  "RSS",
+	"VSIZE":   "VSZ",
+	"PCPU":    "%CPU",
+	"PMEM":    "%MEM",
+	"THCNT":   "NLWP",
+	"THCOUNT": "NLWP",
+	"ARGS":    "CMD",
+	"COMMAND": "CMD",
+	"ETIME":   "ELAPSED",
+	"ETIMES":  "ELAPSED",
+	"STATE":   "S",
+	"TT":      "TTY",
+	"NICE":    "NI",
+	"SESS":    "SID",
+	"SESSION": "SID",
+}
+
+// converts all metric names to their canonical form
+func canonicalStats(stat map[string]string) {
+	for key, val := range stat {
+		if name := CanonicalName(key); name != key {
+			if _, ok := stat[name]; !ok {
+				stat[name] = val
+			}
+
+			delete(stat, key)
+		}
+	}
+}
+
+// applies default values and the missing value policy
+func fixMissing(stat map[string]string, opts *Options) {
+	if opts.Missing == MissingKeep && len(opts.Defaults) == 0 {
+		return
+	}
+
+	for key, val := range stat {
+		if !isMissing(val) {
+			continue
+		}
+
+		if def, ok := opts.Defaults[key]; ok {
+			stat[key] = def
+			continue
+		}
+
+		switch opts.Missing {
+		case MissingEmpty:
+			stat[key] = ""
+		case MissingSkip:
+			delete(stat, key)
+		}
+	}
+}
+
+// checks if the value is reported by 'ps' as unavailable
+func isMissing(val string) bool {
+	return len(val) == 0 || val == "-"
+}
+
+// parses the output of 'date +%s%N'; some 'date' implementations (e.g., BusyBox) do not support
+// nanoseconds and print either "N" or nothing instead
+func parseRemoteTime(s string) (time.Time, error) {
+	digits := strings.TrimSuffix(s, "N")
+
+	if val, err := strconv.ParseInt(digits, 10, 64); err == nil && val >= 0 {
+		if len(digits) > 10 {
+			return time.Unix(0, val), nil
+		}
+
+		return time.Unix(val, 0), nil
+	}
+
+	return time.Time{}, fmt.Errorf("Invalid remote time: %q", s)
+}
+
+// reads pid or similar non-negative integer from string map
+func getPid(stats map[string]string, key string) (val int, err error) {
+	str := stats[key]
+
+	if len(str) == 0 {
+		err = fmt.Errorf("Metric %q is not found or empty", key)
+	} else if val, err = strconv.Atoi(str); err != nil {
+		if e, ok := err.(*strconv.NumError); ok {
+			err = fmt.Errorf("Parsing metric %s %q: %s", key, str, e.Err)
+		}
+	} else if val < 0 {
+		err = fmt.Errorf("Parsing metric %s %q: negative value", key, str)
+	}
+
+	return
+}
+
+// error mapper for parser
+func mapCmdError(err error) error {
 	switch e := err.(type) {
+	case *ParseError:
+		return e
+
 	case *strit.ExitError:
 		msg := e.Stderr
 
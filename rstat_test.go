@@ -30,10 +30,12 @@ package rstat
 
 import (
 	"fmt"
+	"os"
 	"sort"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/maxim2266/strit"
 )
@@ -49,6 +51,12 @@ func TestSSHCmdBuilder(t *testing.T) {
 		{SSHCommand("192.168.0.16", "pi", "raspberry", 0), "sshpass -p raspberry ssh pi@192.168.0.16"},
 		{SSHCommand("192.168.0.16", "pi", "", 5), "ssh -o ConnectTimeout=5 pi@192.168.0.16"},
 		{SSHCommand("192.168.0.16", "pi", "raspberry", 5), "sshpass -p raspberry ssh -o ConnectTimeout=5 pi@192.168.0.16"},
+		{SSHCommand("192.168.0.16", "", "", 0), "ssh 192.168.0.16"},
+		{SSHCommand("fe80::1", "pi", "", 0), "ssh -6 pi@fe80::1"},
+		{SSHCommand("[fe80::1%eth0]", "pi", "", 5), "ssh -6 -o ConnectTimeout=5 pi@fe80::1%eth0"},
+		{SSHCommand("[::ffff:192.168.0.16]", "", "", 0), "ssh -6 ::ffff:192.168.0.16"},
+		{SSHConfigCommand("rpi", "", 0), "ssh rpi"},
+		{SSHConfigCommand("rpi", "/etc/fleet/ssh_config", 5), "ssh -F /etc/fleet/ssh_config -o ConnectTimeout=5 rpi"},
 	}
 
 	for _, tst := range tests {
@@ -71,7 +79,9 @@ func TestCommandBuilder(t *testing.T) {
 		{[]string{"command=Command:42"}, "ps -ewwo pid,ppid -o cmd=Command"},
 		{[]string{"command="}, "ps -ewwo pid,ppid -o cmd"},
 		{[]string{"pid=XXX:15"}, "ps -ewwo pid,ppid"},
-		{[]string{"start=Start:42", "state=", "util:15"}, "ps -ewwo pid,ppid -o start=Start -o state -o util"},
+		{[]string{"start=Start:42", "state=", "util:15"}, "ps -ewwo pid,ppid -o start:42=Start -o state -o util:15"},
+		{[]string{"user", "comm:x", "wchan:10=Wait", "rss:0"}, "ps -ewwo pid,ppid -o comm:64 -o rss -o user:32 -o wchan:10=Wait"},
+		{[]string{"user", "user:16", "user=Owner", "cmd:20"}, "ps -ewwo pid,ppid -o cmd -o user:32 -o user:32=Owner"},
 		{[]string{"args", "command", "cmd"}, "ps -ewwo pid,ppid -o cmd"},
 		{[]string{"%cpu", "%cpu", "%cpu"}, "ps -ewwo pid,ppid -o %cpu"},
 	}
@@ -88,6 +98,260 @@ func TestCommandBuilder(t *testing.T) {
 	}
 }
 
+func TestColumnWiseCommandBuilder(t *testing.T) {
+	type test struct {
+		cols []string
+		exp  string
+	}
+
+	tests := []test{
+		{[]string{"lstart", "pid"}, "ps -ewwo pid,ppid && ps -ewwo pid -o lstart"},
+		{[]string{"cmd=Command Line", "%cpu"}, "ps -ewwo pid,ppid && ps -ewwo pid -o %cpu && ps -ewwo pid -o 'cmd=Command Line'"},
+		{[]string{"comm=It's"}, `ps -ewwo pid,ppid && ps -ewwo pid -o 'comm:64=It'\''s'`},
+	}
+
+	for _, tst := range tests {
+		if s := makeColumnWiseScript(tst.cols); s != tst.exp {
+			t.Errorf("Invalid script:\nexp: %q\ngot: %q", tst.exp, s)
+			return
+		}
+	}
+
+	cmd := makeCommand([]string{"ssh", "pi@host"}, []string{"lstart"}, &Options{ColumnWise: true})
+
+	if s := strings.Join(cmd, " "); s != "ssh pi@host sh -c 'ps -ewwo pid,ppid && ps -ewwo pid -o lstart'" {
+		t.Errorf("Invalid command: %q", s)
+		return
+	}
+}
+
+func TestColumnWise(t *testing.T) {
+	snap, err := collect(cat("column-wise"), &Options{ColumnWise: true})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(snap.Warnings) != 1 {
+		t.Errorf("Unexpected number of warnings: %d instead of 1", len(snap.Warnings))
+		return
+	}
+
+	var count int
+
+	snap.Root.ForEach(func(node *ProcNode) {
+		count++
+
+		if len(node.Stats) != 2 || len(node.Stats["STARTED"]) != 24 || len(node.Stats["CMD"]) == 0 {
+			t.Errorf("Invalid stats for pid %d: %v", node.Pid, node.Stats)
+		}
+	})
+
+	if count != 3 {
+		t.Errorf("Unexpected number of nodes: %d instead of 3", count)
+		return
+	}
+}
+
+func TestRemoteClock(t *testing.T) {
+	cmd := makeCommand([]string{"ssh", "pi@host"}, []string{"rss"}, &Options{RemoteClock: true})
+	exp := `ssh pi@host sh -c 'date '\''+@time %s%N'\'' && ps -ewwo pid,ppid -o rss'`
+
+	if s := strings.Join(cmd, " "); s != exp {
+		t.Errorf("Invalid command string:\nexp: %q\ngot: %q", exp, s)
+		return
+	}
+
+	snap, err := collect(cat("remote-clock"), nil)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if ts := snap.RemoteTime.UnixNano(); ts != 1534775521123456789 {
+		t.Errorf("Unexpected remote time: %d", ts)
+		return
+	}
+
+	if skew := snap.Skew(); skew > -time.Hour {
+		t.Errorf("Unexpected clock skew: %s", skew)
+		return
+	}
+
+	// local machine
+	if snap, err = Collect(nil, &Options{RemoteClock: true}, "rss"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if skew := snap.Skew(); snap.RemoteTime.IsZero() || skew > time.Second || skew < -time.Second {
+		t.Errorf("Unexpected local clock skew: %s", skew)
+		return
+	}
+}
+
+func TestCommandPreview(t *testing.T) {
+	type test struct {
+		opts        *Options
+		cmd, script string
+	}
+
+	ssh := SSHCommand("192.168.0.16", "pi", "", 5)
+
+	tests := []test{
+		{nil, "ssh -o ConnectTimeout=5 pi@192.168.0.16 ps -ewwo pid,ppid -o rss", "ps -ewwo pid,ppid -o rss"},
+		{&Options{CPUCount: true},
+			`ssh -o ConnectTimeout=5 pi@192.168.0.16 sh -c 'echo "@ncpu $(nproc 2>/dev/null || grep -c ^processor /proc/cpuinfo)" && ps -ewwo pid,ppid -o rss'`,
+			`echo "@ncpu $(nproc 2>/dev/null || grep -c ^processor /proc/cpuinfo)" && ps -ewwo pid,ppid -o rss`},
+		{&Options{Chroot: "/mnt/target"},
+			"ssh -o ConnectTimeout=5 pi@192.168.0.16 chroot /mnt/target ps -ewwo pid,ppid -o rss",
+			"chroot /mnt/target ps -ewwo pid,ppid -o rss"},
+		{&Options{Chroot: "/mnt/target", CPUCount: true},
+			`ssh -o ConnectTimeout=5 pi@192.168.0.16 chroot /mnt/target sh -c 'echo "@ncpu $(nproc 2>/dev/null || grep -c ^processor /proc/cpuinfo)" && ps -ewwo pid,ppid -o rss'`,
+			`chroot /mnt/target sh -c 'echo "@ncpu $(nproc 2>/dev/null || grep -c ^processor /proc/cpuinfo)" && ps -ewwo pid,ppid -o rss'`},
+		{&Options{Namespace: 42},
+			"ssh -o ConnectTimeout=5 pi@192.168.0.16 nsenter -t 42 -p -m ps -ewwo pid,ppid -o rss",
+			"nsenter -t 42 -p -m ps -ewwo pid,ppid -o rss"},
+		{&Options{Namespace: 42, CPUCount: true},
+			`ssh -o ConnectTimeout=5 pi@192.168.0.16 nsenter -t 42 -p -m sh -c 'echo "@ncpu $(nproc 2>/dev/null || grep -c ^processor /proc/cpuinfo)" && ps -ewwo pid,ppid -o rss'`,
+			`nsenter -t 42 -p -m sh -c 'echo "@ncpu $(nproc 2>/dev/null || grep -c ^processor /proc/cpuinfo)" && ps -ewwo pid,ppid -o rss'`},
+	}
+
+	for _, tst := range tests {
+		if s := strings.Join(Command(ssh, tst.opts, "rss"), " "); s != tst.cmd {
+			t.Errorf("Invalid command:\nexp: %q\ngot: %q", tst.cmd, s)
+			return
+		}
+
+		if s := Script(tst.opts, "rss"); s != tst.script {
+			t.Errorf("Invalid script:\nexp: %q\ngot: %q", tst.script, s)
+			return
+		}
+	}
+
+	// multiple columns: the preview must be stable, and the same as the command actually run
+	columns := []string{"cmd", "rss", "vsz", "%cpu", "nlwp", "user", "wchan"}
+	exp := "ssh -o ConnectTimeout=5 pi@192.168.0.16 ps -ewwo pid,ppid -o rss -o vsz -o %cpu -o nlwp -o user:32 -o wchan:32 -o cmd"
+
+	for i := 0; i < 20; i++ {
+		if s := strings.Join(Command(ssh, nil, columns...), " "); s != exp {
+			t.Errorf("Invalid command:\nexp: %q\ngot: %q", exp, s)
+			return
+		}
+
+		if s := strings.Join(makeCommand(ssh, columns, &Options{}), " "); s != exp {
+			t.Errorf("Invalid command:\nexp: %q\ngot: %q", exp, s)
+			return
+		}
+	}
+}
+
+func TestRestrictedMode(t *testing.T) {
+	opts := &Options{Restricted: true, RemoteClock: true, SchedStats: true, Publish: new(SnapshotVar)}
+	exp := "ssh pi@host ps -e -o pid,ppid -o user -o pcpu=CPU -o args"
+
+	if s := strings.Join(Command([]string{"ssh", "pi@host"}, opts, "cmd:20", "user:32", "wchan", "pcpu=CPU", "user"), " "); s != exp {
+		t.Errorf("Invalid command:\nexp: %q\ngot: %q", exp, s)
+		return
+	}
+
+	if s := fmt.Sprint(opts.Disabled()); s != "[RemoteClock SchedStats]" {
+		t.Errorf("Unexpected list of disabled options: %s", s)
+		return
+	}
+
+	if s := fmt.Sprint(RestrictedModeDisabled()); s != "[ColumnWise RemoteClock BootTime CPUCount MemoryDetails SchedStats Enrichers]" {
+		t.Errorf("Unexpected list of options disabled in restricted mode: %s", s)
+		return
+	}
+
+	snap, err := Collect(nil, opts, "pcpu", "rss", "args")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(snap.Warnings) != 3 {
+		t.Errorf("Unexpected warnings: %q", snap.Warnings)
+		return
+	}
+
+	if pub := opts.Publish.Snapshot(); pub != snap || !strings.Contains(opts.Publish.String(), "disabled in restricted mode") {
+		t.Error("Published snapshot has no restricted mode warnings")
+		return
+	}
+
+	if _, ok := snap.Root.Stats["COMMAND"]; !ok {
+		t.Errorf("Command column is not found")
+		return
+	}
+
+	// enrichers are not attached
+	counter := &lineCounter{attached: -1}
+
+	if _, err = Collect(nil, &Options{Restricted: true, Enrichers: []Enricher{Mandatory(counter)}}, "rss"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if counter.attached != -1 {
+		t.Error("Enricher is attached in restricted mode")
+		return
+	}
+}
+
+func TestCompression(t *testing.T) {
+	opts := &Options{Compress: true}
+
+	type test struct {
+		ssh []string
+		exp string
+	}
+
+	tests := []test{
+		{SSHCommand("192.168.0.16", "pi", "", 5), "ssh -C -o ConnectTimeout=5 pi@192.168.0.16 ps -ewwF"},
+		{SSHCommand("192.168.0.16", "pi", "raspberry", 0), "sshpass -p raspberry ssh -C pi@192.168.0.16 ps -ewwF"},
+		{SSHCommand("192.168.0.16", "pi", "ssh", 0), "sshpass -p ssh ssh -C pi@192.168.0.16 ps -ewwF"},
+		{SSHCommand("192.168.0.16", "pi", "a/ssh", 0), "sshpass -p a/ssh ssh -C pi@192.168.0.16 ps -ewwF"},
+		{nil, "ps -ewwF"},
+	}
+
+	for _, tst := range tests {
+		if s := strings.Join(Command(tst.ssh, opts), " "); s != tst.exp {
+			t.Errorf("Invalid command:\nexp: %q\ngot: %q", tst.exp, s)
+			return
+		}
+	}
+
+	snap, err := collect(cat("valid-data"), nil)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if info, err := os.Stat(dataDir + "valid-data"); err != nil || info.Size() != snap.Bytes {
+		t.Errorf("Unexpected number of bytes: %d", snap.Bytes)
+		return
+	}
+
+	// compression without ssh: the published snapshot has the warning too
+	opts.Publish = new(SnapshotVar)
+
+	if snap, err = Collect([]string{"env"}, opts, "rss"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(snap.Warnings) != 1 || !strings.Contains(opts.Publish.String(), "Compression is requested") {
+		t.Errorf("Unexpected warnings: %q", snap.Warnings)
+		return
+	}
+}
+
 func TestNumberOfRecords(t *testing.T) {
 	n, err := lc("valid-data")
 
@@ -177,6 +441,45 @@ func TestTree(t *testing.T) {
 	}
 }
 
+func TestSafeTraversal(t *testing.T) {
+	root, err := pstree(cat("valid-data"))
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var visited bool
+
+	err = root.SafeForEach(func(node *ProcNode) {
+		switch node.Pid {
+		case 2239:
+			var m map[string]int
+
+			m["x"]++
+		case 2242: // child of 2239
+			visited = true
+		}
+	})
+
+	if e, ok := err.(*PanicError); !ok || e.Pid != 2239 || len(e.Stack) == 0 {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	if visited {
+		t.Error("Traversal is not stopped after panic")
+		return
+	}
+
+	node, err := root.SafeFind(func(node *ProcNode) bool { return node.Pid == 2239 })
+
+	if err != nil || node == nil || node.Pid != 2239 {
+		t.Errorf("Unexpected result: %v, %v", node, err)
+		return
+	}
+}
+
 func TestParserErrorDetection(t *testing.T) {
 	type test struct {
 		file, msg string
@@ -201,6 +504,240 @@ func TestParserErrorDetection(t *testing.T) {
 	}
 }
 
+func TestParseErrors(t *testing.T) {
+	type test struct {
+		src  string
+		line int
+	}
+
+	tests := []test{
+		{"PID\n1\n", 1},
+		{"PID PPID PID\n1 0 1\n", 1},
+		{"PID PPID\x00\n1 0\n", 1},
+		{"PID PPID\n\n1 0\n\n2\n", 5},
+		{"PID PPID\n1 0\n2 x\n", 3},
+		{"PID PPID\n1 0\n" + strings.Repeat("x", 2<<20) + "\n", 3},
+	}
+
+	for _, tst := range tests {
+		_, err := Parse(strings.NewReader(tst.src), nil)
+
+		if err == nil {
+			t.Errorf("Error not detected in %q", tst.src)
+			return
+		}
+
+		e, ok := err.(*ParseError)
+
+		if !ok {
+			t.Errorf("Unexpected error type %T: %s", err, err)
+			return
+		}
+
+		if e.Line != tst.line {
+			t.Errorf("Unexpected line number in %q: %d instead of %d", e, e.Line, tst.line)
+			return
+		}
+	}
+}
+
+func TestParserLoops(t *testing.T) {
+	snap, err := Parse(strings.NewReader("PID PPID\n1 2\n2 1\n3 3\n4 1\n"), nil)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var count int
+
+	snap.Root.ForEach(func(_ *ProcNode) {
+		count++
+	})
+
+	if count != 3 {
+		t.Errorf("Unexpected number of nodes: %d instead of 3", count)
+		return
+	}
+}
+
+func TestUnparented(t *testing.T) {
+	snap, err := Parse(strings.NewReader("PID PPID\n1 0\n2 0\n3 2\n4 5\n5 4\n6 6\n7 1\n"), nil)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	exp := [][]int{{2, 3}, {6}, {4, 5}}
+
+	if len(snap.Unparented) != len(exp) {
+		t.Errorf("Unexpected number of unparented processes: %d instead of %d", len(snap.Unparented), len(exp))
+		return
+	}
+
+	for i, node := range snap.Unparented {
+		var pids []int
+
+		node.ForEach(func(n *ProcNode) {
+			pids = append(pids, n.Pid)
+		})
+
+		if fmt.Sprint(pids) != fmt.Sprint(exp[i]) {
+			t.Errorf("Unexpected unparented subtree: %v instead of %v", pids, exp[i])
+			return
+		}
+	}
+
+	if n := snap.Root.ProcessCount(); n != 2 {
+		t.Errorf("Unexpected number of processes: %d instead of 2", n)
+		return
+	}
+}
+
+func TestChildCounts(t *testing.T) {
+	src := "PID PPID\n1 0\n2 0\n3 2\n4 5\n5 4\n7 1\n8 7\n9 7\n10 9\n"
+	snap, err := Parse(strings.NewReader(src), &Options{ChildCounts: true})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	exp := map[int]string{1: "1 4", 2: "1 1", 3: "0 0", 4: "1 1", 5: "0 0", 7: "2 3", 8: "0 0", 9: "1 1", 10: "0 0"}
+
+	snap.forEach(func(node *ProcNode) {
+		if s := node.Stats["CHILDREN"] + " " + node.Stats["DESCENDANTS"]; s != exp[node.Pid] {
+			t.Errorf("Unexpected counts for pid %d: %q instead of %q", node.Pid, s, exp[node.Pid])
+		}
+	})
+}
+
+func TestLimits(t *testing.T) {
+	type test struct {
+		limits  Limits
+		count   int
+		warning string
+	}
+
+	tests := []test{
+		{Limits{}, 23, ""},
+		{Limits{MaxProcesses: 5}, 5, "Process limit of 5 is reached: 18 processes dropped"},
+		{Limits{MaxBytes: 500}, 4, "Output size limit of 500 bytes is reached: the rest is dropped"},
+	}
+
+	for _, tst := range tests {
+		snap, err := collect(cat("valid-data"), &Options{Limits: tst.limits})
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if n := snap.Root.ProcessCount(); n != tst.count {
+			t.Errorf("Unexpected number of processes: %d instead of %d", n, tst.count)
+			return
+		}
+
+		if snap.Truncated != (len(tst.warning) > 0) {
+			t.Errorf("Unexpected truncation flag: %t", snap.Truncated)
+			return
+		}
+
+		if len(tst.warning) > 0 && (len(snap.Warnings) != 1 || snap.Warnings[0] != tst.warning) {
+			t.Errorf("Unexpected warnings: %q", snap.Warnings)
+			return
+		}
+	}
+
+	// enrichment time
+	opts := &Options{
+		Enrichers: []Enricher{ProcStatsEnricher("slow", "echo 1 FOO bar; sleep 5")},
+		Limits:    Limits{MaxEnrichmentTime: 500 * time.Millisecond},
+	}
+
+	snap, err := Collect(nil, opts, "rss")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !snap.Truncated || snap.Root.Stats["FOO"] != "bar" || len(snap.Warnings) != 1 {
+		t.Errorf("Unexpected result of timed out enrichment: %t, %v, %q", snap.Truncated, snap.Root.Stats, snap.Warnings)
+		return
+	}
+
+	// the enricher command is requested once per collection
+	counter := new(commandCounter)
+	opts.Enrichers = []Enricher{counter}
+
+	if _, err = Collect(nil, opts, "rss"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if counter.calls != 1 {
+		t.Errorf("Enricher command is requested %d times", counter.calls)
+		return
+	}
+}
+
+type commandCounter struct {
+	lineCounter
+	calls int
+}
+
+func (c *commandCounter) Command() string {
+	c.calls++
+	return c.lineCounter.Command()
+}
+
+func TestDuplicatePids(t *testing.T) {
+	if _, err := pstree(cat("duplicate-pid")); err == nil {
+		t.Error("Duplicate PID is not detected")
+		return
+	}
+
+	// ProcTree() does not fail
+	if _, err := ProcTree([]string{"sh", "-c", "cat " + dataDir + "duplicate-pid", "--"}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, policy := range []DupPolicy{DupFirstWins, DupMergeThreads} {
+		snap, err := collect(cat("duplicate-pid"), &Options{Duplicates: policy})
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(snap.Warnings) != 1 {
+			t.Errorf("Unexpected number of warnings: %d instead of 1", len(snap.Warnings))
+			return
+		}
+
+		node := snap.Root.Find(func(node *ProcNode) bool { return node.Pid == 2245 })
+
+		if node == nil {
+			t.Error("PID 2245 not found")
+			return
+		}
+
+		exp := 0
+
+		if policy == DupMergeThreads {
+			exp = 1
+		}
+
+		if len(node.Threads) != exp {
+			t.Errorf("Unexpected number of threads: %d instead of %d", len(node.Threads), exp)
+			return
+		}
+	}
+}
+
 func TestPlatform(t *testing.T) {
 	root, err := ProcTree(nil, "%cpu", "%mem", "command")
 
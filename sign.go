@@ -0,0 +1,106 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// SignedSnapshot is the serialized form of a snapshot with its integrity metadata, as produced
+// by Snapshot.Sign(). The signature covers the snapshot data, the algorithm name, and the signing time.
+type SignedSnapshot struct {
+	Snapshot  json.RawMessage // snapshot in JSON format
+	Algorithm string          // signature algorithm, "HMAC-SHA256"
+	SignedAt  time.Time       // local time of signing
+	Signature string          // hex-encoded signature
+}
+
+const signAlgorithm = "HMAC-SHA256"
+
+// Sign serializes the snapshot to JSON and signs it with HMAC-SHA256 using the given key, so that
+// an archived snapshot can later be shown to be untampered (see VerifySnapshot()). The result is
+// the JSON form of SignedSnapshot.
+func (snap *Snapshot) Sign(key []byte) ([]byte, error) {
+	data, err := json.Marshal(snap)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := SignedSnapshot{
+		Snapshot:  data,
+		Algorithm: signAlgorithm,
+		SignedAt:  time.Now().UTC(),
+	}
+
+	res.Signature = hex.EncodeToString(res.mac(key))
+	return json.Marshal(&res)
+}
+
+// VerifySnapshot verifies the signed snapshot (as produced by Snapshot.Sign()) with the given key,
+// and returns the deserialized snapshot along with the signing time. An error is returned if the data
+// cannot be parsed, or the signature does not match.
+func VerifySnapshot(data, key []byte) (*Snapshot, time.Time, error) {
+	var signed SignedSnapshot
+
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return nil, time.Time{}, errors.New("Invalid signed snapshot: " + err.Error())
+	}
+
+	if signed.Algorithm != signAlgorithm {
+		return nil, time.Time{}, errors.New("Unsupported signature algorithm: " + signed.Algorithm)
+	}
+
+	sig, err := hex.DecodeString(signed.Signature)
+
+	if err != nil || !hmac.Equal(sig, signed.mac(key)) {
+		return nil, time.Time{}, errors.New("Snapshot signature does not match")
+	}
+
+	snap := new(Snapshot)
+
+	if err = json.Unmarshal(signed.Snapshot, snap); err != nil {
+		return nil, time.Time{}, errors.New("Invalid signed snapshot: " + err.Error())
+	}
+
+	return snap, signed.SignedAt, nil
+}
+
+func (s *SignedSnapshot) mac(key []byte) []byte {
+	m := hmac.New(sha256.New, key)
+
+	m.Write([]byte(s.Algorithm + "\n" + s.SignedAt.Format(time.RFC3339Nano) + "\n"))
+	m.Write(s.Snapshot)
+	return m.Sum(nil)
+}
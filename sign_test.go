@@ -0,0 +1,77 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+	snap, err := collect(cat("valid-data"), nil)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	key := []byte("secret")
+	data, err := snap.Sign(key)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, _, err := VerifySnapshot(data, key)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res.Root.ProcessCount() != snap.Root.ProcessCount() || res.Root.TotalRSS() != snap.Root.TotalRSS() {
+		t.Error("Verified snapshot differs from the original one")
+		return
+	}
+
+	// wrong key
+	if _, _, err = VerifySnapshot(data, []byte("wrong")); err == nil {
+		t.Error("Wrong key is not detected")
+		return
+	}
+
+	// tampered data
+	data = bytes.Replace(data, []byte(`"RSS":"4296"`), []byte(`"RSS":"4295"`), 1)
+
+	if _, _, err = VerifySnapshot(data, key); err == nil {
+		t.Error("Tampered data is not detected")
+		return
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// StatsD is an emitter of snapshot metrics as StatsD gauges. By default the gauges are written
+// in DogStatsD format, with the host, pid, and process name passed as tags, like in
+// "rstat.proc.rss:4296|g|#host:rpi,pid:117,name:systemd-journald". With the 'Plain' flag set the tags
+// are not used, instead the host, pid, and process name become a part of the gauge name, like in
+// "rstat.rpi.proc.117.systemd-journald.rss:4296|g", which suits the original StatsD daemon.
+type StatsD struct {
+	Prefix  string   // gauge name prefix, "rstat." if empty
+	Tags    []string // additional tags for every gauge, in "key:value" format (DogStatsD only)
+	Metrics []string // per-process metrics to send, only numeric values are sent
+	Totals  bool     // send aggregated gauges: process count, total CPU, RSS, and number of threads
+	Plain   bool     // plain StatsD format, without tags
+}
+
+// Write writes the snapshot gauges to the given writer, one gauge per line.
+func (s *StatsD) Write(w io.Writer, host string, snap *Snapshot) error {
+	out := bufio.NewWriter(w)
+
+	s.gauges(host, snap, func(line string) {
+		out.WriteString(line)
+		out.WriteByte('\n')
+	})
+
+	return out.Flush()
+}
+
+// Send sends the snapshot gauges to the StatsD server at the given UDP address,
+// packing as many gauges into each datagram as the typical network MTU allows.
+func (s *StatsD) Send(addr, host string, snap *Snapshot) (err error) {
+	var conn net.Conn
+
+	if conn, err = net.Dial("udp", addr); err != nil {
+		return
+	}
+
+	defer conn.Close()
+
+	const maxPacket = 1432
+
+	var buf bytes.Buffer
+
+	s.gauges(host, snap, func(line string) {
+		if err != nil {
+			return
+		}
+
+		if buf.Len() > 0 && buf.Len()+len(line)+1 > maxPacket {
+			_, err = conn.Write(buf.Bytes())
+			buf.Reset()
+		}
+
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+
+		buf.WriteString(line)
+	})
+
+	if err == nil && buf.Len() > 0 {
+		_, err = conn.Write(buf.Bytes())
+	}
+
+	return
+}
+
+// gauge generator
+func (s *StatsD) gauges(host string, snap *Snapshot, fn func(string)) {
+	prefix := s.Prefix
+
+	if len(prefix) == 0 {
+		prefix = "rstat."
+	}
+
+	if s.Plain {
+		prefix += graphiteName(host) + "."
+	}
+
+	// aggregated gauges
+	if s.Totals {
+		tags := s.tags("host:" + host)
+
+		fn(prefix + "processes:" + strconv.Itoa(snap.Root.ProcessCount()) + "|g" + tags)
+		fn(prefix + "cpu:" + formatFloat(snap.Root.TotalCPU()) + "|g" + tags)
+		fn(prefix + "rss:" + strconv.FormatInt(snap.Root.TotalRSS(), 10) + "|g" + tags)
+		fn(prefix + "threads:" + strconv.FormatInt(snap.Root.TotalThreads(), 10) + "|g" + tags)
+	}
+
+	if len(s.Metrics) == 0 {
+		return
+	}
+
+	// per-process gauges
+	snap.Root.ForEach(func(node *ProcNode) {
+		var name, tags string
+
+		if s.Plain {
+			name = prefix + "proc." + strconv.Itoa(node.Pid) + "." + graphiteName(procName(node)) + "."
+		} else {
+			name = prefix + "proc."
+			tags = s.tags("host:"+host, "pid:"+strconv.Itoa(node.Pid), "name:"+procName(node))
+		}
+
+		for _, key := range s.Metrics {
+			if val, ok := node.Float(key); ok {
+				fn(name + graphiteName(strings.TrimPrefix(key, "%")) + ":" + formatFloat(val) + "|g" + tags)
+			}
+		}
+	})
+}
+
+// DogStatsD tag list
+func (s *StatsD) tags(tags ...string) string {
+	if s.Plain {
+		return ""
+	}
+
+	return "|#" + strings.Join(append(tags, s.Tags...), ",")
+}
+
+func formatFloat(val float64) string {
+	return strconv.FormatFloat(val, 'f', -1, 64)
+}
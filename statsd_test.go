@@ -0,0 +1,76 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStatsD(t *testing.T) {
+	snap, err := collect(cat("valid-data"), nil)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	type test struct {
+		emitter StatsD
+		exp     []string
+	}
+
+	tests := []test{
+		{StatsD{Metrics: []string{"RSS"}, Totals: true, Tags: []string{"env:test"}}, []string{
+			"rstat.processes:23|g|#host:rpi,env:test",
+			"rstat.rss:84932|g|#host:rpi,env:test",
+			"rstat.proc.rss:4296|g|#host:rpi,pid:117,name:systemd-journald,env:test",
+		}},
+		{StatsD{Prefix: "dev.", Metrics: []string{"RSS"}, Plain: true}, []string{
+			"dev.rpi.proc.117.systemd-journald.rss:4296|g",
+		}},
+	}
+
+	for _, tst := range tests {
+		var buf bytes.Buffer
+
+		if err = tst.emitter.Write(&buf, "rpi", snap); err != nil {
+			t.Error(err)
+			return
+		}
+
+		for _, exp := range tst.exp {
+			if !strings.Contains(buf.String(), exp+"\n") {
+				t.Errorf("Gauge not found: %q", exp)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"context"
+	"errors"
+
+	"github.com/maxim2266/strit"
+)
+
+// CollectStream invokes 'ps' like Collect() does, but instead of building the process tree it calls
+// the given function for each process as soon as its record is read, so the memory usage does not
+// depend on the number of processes. The nodes passed to the function have no children, and
+// the function may keep them. Any error returned from the function stops the collection,
+// and it is returned from CollectStream() as is. Of the options only those controlling the command
+// (Restricted, Compress, SwitchUser, Chroot, Namespace) and the values (Canonical, Missing, Defaults)
+// are applied, all the others are ignored, because they either need the whole process tree or produce
+// data for the Snapshot.
+// Duplicate pids are not detected. Parameter 'opts' can be nil.
+func CollectStream(ssh []string, opts *Options, fn func(*ProcNode) error, columns ...string) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	if err := opts.SwitchUser.check(ssh, opts.Restricted); err != nil {
+		return err
+	}
+
+	cmdOpts := &Options{
+		Restricted: opts.Restricted,
+		Compress:   opts.Compress,
+		SwitchUser: opts.SwitchUser,
+		Chroot:     opts.Chroot,
+		Namespace:  opts.Namespace,
+	}
+
+	return stream(makeCommand(ssh, columns, cmdOpts), opts, fn)
+}
+
+func stream(cmd []string, opts *Options, fn func(*ProcNode) error) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	p := psParser{skipToBegin: opts.SwitchUser.pty()}
+	var fnErr error
+
+	p.emit = func(stat map[string]string) (err error) {
+		if opts.Canonical {
+			canonicalStats(stat)
+		}
+
+		node := &ProcNode{Stats: stat}
+
+		if node.Pid, err = getPid(stat, "PID"); err != nil {
+			return &ParseError{Line: p.lineNo, Msg: err.Error()}
+		}
+
+		if node.ParentPid, err = getPid(stat, "PPID"); err != nil {
+			return &ParseError{Line: p.lineNo, Msg: err.Error()}
+		}
+
+		delete(stat, "PID")
+		delete(stat, "PPID")
+		fixMissing(stat, opts)
+
+		if fnErr = fn(node); fnErr != nil {
+			return errStopStream
+		}
+
+		return nil
+	}
+
+	err := p.lines(strit.FromCommand(opts.command(context.Background(), cmd))).Parse(&p)
+
+	if fnErr != nil {
+		return fnErr
+	}
+
+	return err
+}
+
+// pseudo-error stopping the stream, never returned to the caller
+var errStopStream = errors.New("Stream stopped")
@@ -0,0 +1,127 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	var count int
+	var rss int64
+
+	err := stream(cat("valid-data"), &Options{Canonical: true}, func(node *ProcNode) error {
+		if node.Pid == 0 || len(node.Children) > 0 {
+			t.Errorf("Unexpected node: %+v", node)
+		}
+
+		if val, ok := node.RSS(); ok {
+			rss += val
+		}
+
+		if _, ok := node.Stats["PID"]; ok {
+			t.Errorf("Unexpected PID metric for pid %d", node.Pid)
+		}
+
+		count++
+		return nil
+	})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if count != 23 || rss != 84932 {
+		t.Errorf("Unexpected result: %d processes, %d RSS", count, rss)
+		return
+	}
+
+	// early stop
+	stop := errors.New("stop")
+	count = 0
+
+	err = stream(cat("valid-data"), nil, func(node *ProcNode) error {
+		if count++; count == 3 {
+			return stop
+		}
+
+		return nil
+	})
+
+	if err != stop || count != 3 {
+		t.Errorf("Unexpected result: %v after %d processes", err, count)
+		return
+	}
+}
+
+func TestStreamChroot(t *testing.T) {
+	// the fake remote shell only produces the data when invoked with the chroot wrapper
+	ssh := []string{"sh", "-c", `[ "$1" = chroot ] && [ "$2" = /mnt/target ] && cat ` + dataDir + "valid-data", "--"}
+
+	var count int
+
+	err := CollectStream(ssh, &Options{Chroot: "/mnt/target"}, func(*ProcNode) error {
+		count++
+		return nil
+	}, "rss")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if count != 23 {
+		t.Errorf("Unexpected number of processes: %d instead of 23", count)
+		return
+	}
+}
+
+func TestStreamNamespace(t *testing.T) {
+	// the fake remote shell only produces the data when invoked with the nsenter wrapper
+	ssh := []string{"sh", "-c", `[ "$1" = nsenter ] && [ "$3" = 42 ] && cat ` + dataDir + "valid-data", "--"}
+
+	var count int
+
+	err := CollectStream(ssh, &Options{Namespace: 42}, func(*ProcNode) error {
+		count++
+		return nil
+	}, "rss")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if count != 23 {
+		t.Errorf("Unexpected number of processes: %d instead of 23", count)
+		return
+	}
+}
@@ -0,0 +1,281 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Text is a writer of snapshots in 'ps'-like columnar text format, with one line per process
+// and the columns aligned, so that the output can replace 'ssh host ps' in existing shell
+// scripts. By default numeric columns are aligned to the right, other columns to the left.
+type Text struct {
+	// Columns to write, in order, like "PID", "%CPU", or "CMD". Besides the snapshot metrics
+	// the pseudo-columns "PID" and "PPID" are also available. When empty, the PID, PPID, and all
+	// the snapshot metrics are written, with the command column last.
+	Columns []string
+
+	// Indent the command column to show the process hierarchy, like 'ps -H' does.
+	// Without this flag the processes are written in the order of their pids.
+	Tree bool
+
+	// Optional formatting of the columns, by column name.
+	Format map[string]ColumnFormat
+
+	// Colorize the values according to the column formats, using ANSI escape sequences.
+	Color bool
+}
+
+// Write writes the snapshot to the given writer.
+func (t *Text) Write(w io.Writer, snap *Snapshot) error {
+	header, rows := tableRows(snap, t.Columns, t.Tree)
+
+	right := numericColumns(header, rows)
+	colors := make([][]string, len(rows))
+
+	for i := range colors {
+		colors[i] = make([]string, len(header))
+	}
+
+	for i, col := range header {
+		f, ok := t.Format[col]
+
+		if !ok {
+			continue
+		}
+
+		if f.Align != AlignAuto {
+			right[i] = (f.Align == AlignRight)
+		}
+
+		for j, row := range rows {
+			if t.Color {
+				colors[j][i] = f.color(row[i])
+			}
+
+			row[i] = f.render(row[i])
+		}
+	}
+
+	widths := make([]int, len(header))
+
+	for i, name := range header {
+		widths[i] = len(name)
+
+		for _, row := range rows {
+			if n := len(row[i]); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	out := bufio.NewWriter(w)
+
+	for j, row := range append([][]string{header}, rows...) {
+		var line []byte
+
+		for i, s := range row {
+			if i > 0 {
+				line = append(line, ' ')
+			}
+
+			pad := widths[i] - len(s)
+
+			if j > 0 && len(colors[j-1][i]) > 0 {
+				s = "\x1b[" + colors[j-1][i] + "m" + s + "\x1b[0m"
+			}
+
+			if right[i] {
+				line = append(append(line, strings.Repeat(" ", pad)...), s...)
+			} else if line = append(line, s...); i < len(row)-1 {
+				line = append(line, strings.Repeat(" ", pad)...)
+			}
+		}
+
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+
+	return out.Flush()
+}
+
+// table generator, shared by the renderers; the missing values are replaced with "-"
+func tableRows(snap *Snapshot, columns []string, tree bool) (header []string, rows [][]string) {
+	if len(columns) == 0 {
+		columns = allColumns(snap)
+	}
+
+	header = columns
+	cmd := -1
+
+	if tree {
+		for i, col := range columns {
+			if isCmdColumn(col) {
+				cmd = i
+				break
+			}
+		}
+	}
+
+	var add func(node *ProcNode, depth int)
+
+	add = func(node *ProcNode, depth int) {
+		row := tableRow(node, columns)
+
+		if cmd >= 0 {
+			row[cmd] = strings.Repeat("  ", depth) + row[cmd]
+		}
+
+		rows = append(rows, row)
+
+		if tree {
+			for _, child := range byPid(node.Children) {
+				add(child, depth+1)
+			}
+		}
+	}
+
+	if tree {
+		for _, node := range append([]*ProcNode{snap.Root}, byPid(snap.Unparented)...) {
+			add(node, 0)
+		}
+	} else {
+		for _, node := range byPid(allNodes(snap)) {
+			add(node, 0)
+		}
+	}
+
+	return
+}
+
+// row of the values from the given columns
+func tableRow(node *ProcNode, columns []string) []string {
+	row := make([]string, len(columns))
+
+	for i, col := range columns {
+		row[i] = cell(node, col)
+	}
+
+	return row
+}
+
+// flags for the columns where all the values are numeric
+func numericColumns(header []string, rows [][]string) []bool {
+	res := make([]bool, len(header))
+
+	for i := range header {
+		res[i] = true
+
+		for _, row := range rows {
+			res[i] = res[i] && isNumeric(row[i])
+		}
+	}
+
+	return res
+}
+
+// list of all the processes from the snapshot, including the unparented ones
+func allNodes(snap *Snapshot) (nodes []*ProcNode) {
+	snap.forEach(func(node *ProcNode) {
+		nodes = append(nodes, node)
+	})
+
+	return
+}
+
+// PID, PPID, all the metrics in alphabetical order, and the command last
+func allColumns(snap *Snapshot) []string {
+	names := make(map[string]string)
+
+	snap.forEach(func(node *ProcNode) {
+		for key := range node.Stats {
+			names[key] = key
+		}
+	})
+
+	var cmds []string
+
+	columns := []string{"PID", "PPID"}
+
+	for _, key := range sortedKeys(names) {
+		if isCmdColumn(key) {
+			cmds = append(cmds, key)
+		} else {
+			columns = append(columns, key)
+		}
+	}
+
+	return append(columns, cmds...)
+}
+
+// value of the given column, or "-"
+func cell(node *ProcNode, col string) string {
+	switch col {
+	case "PID":
+		return strconv.Itoa(node.Pid)
+	case "PPID":
+		return strconv.Itoa(node.ParentPid)
+	}
+
+	if val, ok := node.Value(col); ok {
+		return val
+	}
+
+	return "-"
+}
+
+func isCmdColumn(col string) bool {
+	switch col {
+	case "CMD", "COMMAND", "ARGS":
+		return true
+	}
+
+	return false
+}
+
+func isNumeric(s string) bool {
+	if s == "-" {
+		return true
+	}
+
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+// sorted copy of the given list of nodes
+func byPid(nodes []*ProcNode) []*ProcNode {
+	res := append([]*ProcNode(nil), nodes...)
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Pid < res[j].Pid })
+	return res
+}
@@ -0,0 +1,83 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	src := "PID PPID RSS S CMD\n1 0 3828 S /sbin/init\n117 1 - S /lib/systemd/systemd-journald\n" +
+		"2233 117 5124 R sshd: pi\n30 1 12 S cron -f\n"
+
+	snap, err := Parse(strings.NewReader(src), nil)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	type test struct {
+		text Text
+		exp  string
+	}
+
+	tests := []test{
+		{Text{}, `
+ PID PPID  RSS S CMD
+   1    0 3828 S /sbin/init
+  30    1   12 S cron -f
+ 117    1    - S /lib/systemd/systemd-journald
+2233  117 5124 R sshd: pi
+`},
+		{Text{Columns: []string{"CMD", "PID", "S"}, Tree: true}, `
+CMD                              PID S
+/sbin/init                         1 S
+  cron -f                         30 S
+  /lib/systemd/systemd-journald  117 S
+    sshd: pi                    2233 R
+`},
+	}
+
+	for _, tst := range tests {
+		var buf bytes.Buffer
+
+		if err = tst.text.Write(&buf, snap); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if s := buf.String(); s != tst.exp[1:] {
+			t.Errorf("Unexpected output:\n%s", s)
+			return
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// UserSwitch specifies the program for running the collection on the target machine as another user.
+// The supported programs are "sudo", "doas" (common on Alpine), and "su" (common on BusyBox devices).
+// Without a password the program must not ask for one (like with NOPASSWD in sudoers, or 'nopass'
+// in doas.conf), otherwise the collection fails instead of waiting for the input. The password
+// for 'sudo' is passed via its standard input; 'doas' and 'su' read the password from the terminal
+// only, so with these programs a password requires the ssh transport, where a pseudo-terminal
+// is allocated (ssh -tt) and the password prompt is skipped. The password cannot be used with 'doas'
+// or 'su' in the restricted mode.
+type UserSwitch struct {
+	Program  string // "sudo", "doas", or "su"
+	User     string // target user, "root" if empty
+	Password string // optional password
+}
+
+// wraps the command for running as another user
+func (u *UserSwitch) wrap(cmd []string) []string {
+	if u == nil {
+		return cmd
+	}
+
+	user := u.User
+
+	if len(user) == 0 {
+		user = "root"
+	}
+
+	switch u.Program {
+	case "sudo":
+		if len(u.Password) > 0 {
+			return concat([]string{"sudo", "-S", "-p", "", "-u", user}, cmd)
+		}
+
+		return concat([]string{"sudo", "-n", "-u", user}, cmd)
+
+	case "doas":
+		if len(u.Password) > 0 {
+			return concat([]string{"doas", "-u", user}, cmd)
+		}
+
+		return concat([]string{"doas", "-n", "-u", user}, cmd)
+
+	default: // su
+		return []string{"su", user, "-c", shellJoin(cmd)}
+	}
+}
+
+// true if the password is to be entered via a pseudo-terminal
+func (u *UserSwitch) pty() bool {
+	return u != nil && len(u.Password) > 0 && u.Program != "sudo"
+}
+
+func (u *UserSwitch) check(ssh []string, restricted bool) error {
+	if u == nil {
+		return nil
+	}
+
+	switch u.Program {
+	case "sudo", "doas", "su":
+		// ok
+	default:
+		return errors.New("Unsupported user switching program: " + strings.TrimSpace(u.Program))
+	}
+
+	if u.pty() {
+		if len(ssh) == 0 {
+			return errors.New("Password for '" + u.Program + "' requires ssh transport")
+		}
+
+		if restricted {
+			return errors.New("Password for '" + u.Program + "' is not supported in restricted mode")
+		}
+	}
+
+	return nil
+}
+
+// marker line separating password prompt from the command output
+const beginMarker = "@begin"
+
+// makes the command to run, with the password on the standard input, if given
+func (opts *Options) command(ctx context.Context, cmd []string) *exec.Cmd {
+	c := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+
+	if u := opts.SwitchUser; u != nil && len(u.Password) > 0 {
+		c.Stdin = strings.NewReader(u.Password + "\n")
+	}
+
+	return c
+}
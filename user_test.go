@@ -0,0 +1,109 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUserSwitchCommand(t *testing.T) {
+	ssh := []string{"ssh", "pi@host"}
+
+	tests := []struct {
+		ssh  []string
+		opts Options
+		exp  string
+	}{
+		{ssh, Options{SwitchUser: &UserSwitch{Program: "sudo"}},
+			"ssh pi@host sudo -n -u root ps -ewwo pid,ppid -o rss"},
+		{ssh, Options{SwitchUser: &UserSwitch{Program: "sudo", Password: "x"}},
+			"ssh pi@host sudo -S -p '' -u root ps -ewwo pid,ppid -o rss"},
+		{ssh, Options{SwitchUser: &UserSwitch{Program: "doas", User: "admin"}, CPUCount: true},
+			`ssh pi@host doas -n -u admin sh -c 'echo "@ncpu $(nproc 2>/dev/null || grep -c ^processor /proc/cpuinfo)" && ps -ewwo pid,ppid -o rss'`},
+		{ssh, Options{SwitchUser: &UserSwitch{Program: "su", Password: "x"}},
+			`ssh -tt pi@host su root -c 'sh -c '\''echo @begin && ps -ewwo pid,ppid -o rss'\'''`},
+		{SSHCommand("host", "pi", "a/ssh", 0), Options{SwitchUser: &UserSwitch{Program: "su", Password: "x"}},
+			`sshpass -p a/ssh ssh -tt pi@host su root -c 'sh -c '\''echo @begin && ps -ewwo pid,ppid -o rss'\'''`},
+		{nil, Options{SwitchUser: &UserSwitch{Program: "su"}, Restricted: true},
+			"su root -c ps -e -o pid,ppid"},
+		{ssh, Options{SwitchUser: &UserSwitch{Program: "sudo"}, Chroot: "/mnt/target image"},
+			"ssh pi@host sudo -n -u root chroot '/mnt/target image' ps -ewwo pid,ppid -o rss"},
+		{ssh, Options{SwitchUser: &UserSwitch{Program: "sudo"}, Namespace: 1234, Restricted: true},
+			"ssh pi@host sudo -n -u root nsenter -t 1234 -p -m ps -e -o pid,ppid"},
+	}
+
+	for _, tst := range tests {
+		if s := strings.Join(Command(tst.ssh, &tst.opts, "rss"), " "); s != tst.exp {
+			t.Errorf("Unexpected command:\n%s\ninstead of\n%s", s, tst.exp)
+		}
+	}
+}
+
+func TestUserSwitchPrompt(t *testing.T) {
+	opts := &Options{SwitchUser: &UserSwitch{Program: "su", Password: "secret"}}
+
+	// password prompt and the echoed newline before the output
+	cmd := []string{"sh", "-c", "read -r p && echo \"Password: $p\" && echo @begin && cat " + dataDir + "valid-data"}
+	snap, err := collect(cmd, opts)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n := snap.Root.ProcessCount(); n != 23 {
+		t.Errorf("Unexpected number of processes: %d instead of 23", n)
+		return
+	}
+
+	// invalid configurations
+	for _, u := range []*UserSwitch{{Program: "runas"}, {Program: "doas", Password: "x"}} {
+		if _, err = Collect(nil, &Options{SwitchUser: u}); err == nil {
+			t.Errorf("Missing error for %+v", u)
+		}
+	}
+}
+
+func TestCollectContainer(t *testing.T) {
+	if _, err := CollectContainer(nil, 0, nil); err == nil {
+		t.Error("Missing error for invalid pid")
+		return
+	}
+
+	// no sudo and no nsenter in the test environment, just make sure the options are not modified
+	opts := &Options{}
+
+	CollectContainer([]string{"false"}, 1234, opts)
+
+	if opts.Namespace != 0 || opts.SwitchUser != nil {
+		t.Errorf("Options are modified: %+v", opts)
+		return
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"context"
+	"time"
+)
+
+// Condition is a predicate on a snapshot, for use with WaitFor(). It returns 'true' when the condition
+// holds, together with the node it refers to, if any.
+type Condition func(snap *Snapshot) (*ProcNode, bool)
+
+// Appears returns a Condition that holds when there is a process matching the given predicate,
+// and it returns the process with the lowest pid of all matching ones.
+func Appears(pred func(*ProcNode) bool) Condition {
+	return func(snap *Snapshot) (res *ProcNode, ok bool) {
+		snap.forEach(func(node *ProcNode) {
+			if (res == nil || node.Pid < res.Pid) && pred(node) {
+				res = node
+			}
+		})
+
+		return res, res != nil
+	}
+}
+
+// Exits returns a Condition that holds when there is no process with the given pid. No node
+// is returned in this case.
+func Exits(pid int) Condition {
+	return func(snap *Snapshot) (_ *ProcNode, ok bool) {
+		ok = true
+
+		snap.forEach(func(node *ProcNode) {
+			ok = ok && node.Pid != pid
+		})
+
+		return
+	}
+}
+
+// WaitFor collects snapshots from the target with the given interval, until either the condition holds,
+// in which case the node returned by the condition is returned, or the context is done, or a collection
+// fails, in which case the corresponding error is returned. The first collection starts immediately.
+// The context also applies to the collection command, which gets killed when the context is done.
+// The parameters 'ssh', 'opts', and 'columns' are the same as for Collect().
+func WaitFor(ctx context.Context, cond Condition, interval time.Duration, ssh []string, opts *Options, columns ...string) (*ProcNode, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		snap, err := collectContext(ctx, ssh, opts, columns...)
+
+		if err != nil {
+			// the command may have been killed
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			return nil, err
+		}
+
+		if node, ok := cond(snap); ok {
+			return node, nil
+		}
+
+		timer := time.NewTimer(interval)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright (c) 2017, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rstat
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitFor(t *testing.T) {
+	cmd := exec.Command("sleep", "0.3")
+
+	if err := cmd.Start(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	pid := cmd.Process.Pid
+	done := make(chan struct{})
+
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the process is there
+	node, err := WaitFor(ctx, Appears(func(node *ProcNode) bool {
+		return strings.HasPrefix(node.Stats["CMD"], "sleep 0.3")
+	}), 50*time.Millisecond, nil, nil, "cmd")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if node.Pid != pid {
+		t.Errorf("Unexpected pid: %d instead of %d", node.Pid, pid)
+		return
+	}
+
+	// wait for the process to exit
+	if _, err = WaitFor(ctx, Exits(pid), 50*time.Millisecond, nil, nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	<-done
+
+	// timeout
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	if _, err = WaitFor(ctx, Exits(os.Getpid()), 50*time.Millisecond, nil, nil); err != context.DeadlineExceeded {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+}
+
+func TestWaitForContext(t *testing.T) {
+	// the context is already done: no collection
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var count int
+
+	_, err := WaitFor(ctx, func(*Snapshot) (*ProcNode, bool) {
+		count++
+		return nil, true
+	}, time.Second, nil, nil)
+
+	if err != context.Canceled || count != 0 {
+		t.Errorf("Unexpected result: %v after %d collections", err, count)
+		return
+	}
+
+	// hung command
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	if _, err = WaitFor(ctx, Exits(1), time.Second, []string{"sh", "-c", "exec sleep 5", "--"}, nil); err != context.DeadlineExceeded {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("The command has not been killed in time: %s", d)
+		return
+	}
+}